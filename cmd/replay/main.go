@@ -0,0 +1,152 @@
+// Command replay feeds a protocol trace recorded with TRACE_FILE through a simulated bulb, to reproduce bugs in
+// parsing or command sequencing offline
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/netip"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/yeelight"
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+func main() {
+	tracePath := flag.String("trace", "", "trace file to replay")
+	bulbAddr := flag.String("bulb", "", "address of the bulb to replay the messages of (default: the first one in the trace)")
+	model := flag.String("model", "color", "model of the simulated bulb")
+	speed := flag.Float64("speed", 1, "replay speed relative to the recording, 0 for as fast as possible")
+	received := flag.Bool("received", false, "send what the bulb sent on the control connection as recorded, in addition to the replies and notifications of the simulated bulb")
+	debug := flag.Bool("debug", false, "enable debug logging")
+	flag.Parse()
+
+	loggerOpts := &slog.HandlerOptions{}
+	if *debug {
+		loggerOpts.Level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, loggerOpts)))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := replay(ctx, *tracePath, *bulbAddr, *model, *speed, *received); err != nil {
+		slog.Error("replay failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+func replay(ctx context.Context, tracePath, bulbAddr, model string, speed float64, received bool) error {
+	if tracePath == "" {
+		return errors.New("no trace file given, use -trace")
+	}
+
+	f, err := os.Open(tracePath)
+	if err != nil {
+		return errors.Wrapf(err, "open trace")
+	}
+	defer f.Close()
+
+	events, err := yeelight.ReadTrace(f)
+	if err != nil {
+		return err
+	}
+
+	events = bulbEvents(events, bulbAddr)
+	if len(events) == 0 {
+		return errors.New("trace has no messages of the bulb")
+	}
+
+	slog.Info("replaying trace", slog.String("bulb", events[0].Bulb), slog.Int("events", len(events)), slog.Duration("duration", events[len(events)-1].Time.Sub(events[0].Time)))
+
+	fake, err := yeelighttest.NewBulb(yeelighttest.Options{Model: model})
+	if err != nil {
+		return err
+	}
+	defer fake.Close()
+
+	bulb := yeelight.NewBulb(fake.Addr())
+	if err := bulb.Connect(ctx); err != nil {
+		return err
+	}
+	defer bulb.Disconnect()
+
+	go logStateChanges(ctx, bulb)
+
+	if received {
+		go sendReceived(ctx, fake, events, speed)
+	}
+
+	server, err := yeelight.NewMusicServer(0, netip.Addr{})
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+
+	if err := bulb.Replay(ctx, events, server, speed); err != nil {
+		return err
+	}
+
+	// Give the last commands and notifications time to arrive
+	time.Sleep(time.Second)
+
+	slog.Info("replay finished", slog.Int("commandsReceived", len(fake.Commands())))
+
+	return nil
+}
+
+// bulbEvents returns the events of the bulb with the address, or of the first bulb if the address is empty
+func bulbEvents(events []yeelight.TraceEvent, addr string) []yeelight.TraceEvent {
+	if addr == "" && len(events) > 0 {
+		addr = events[0].Bulb
+	}
+
+	var filtered []yeelight.TraceEvent
+	for _, event := range events {
+		if event.Bulb == addr {
+			filtered = append(filtered, event)
+		}
+	}
+
+	return filtered
+}
+
+// sendReceived sends the chunks received on the control connection through the simulated bulb with the recorded
+// timing, so that the client parses the same bytes split the same way, invalid messages included
+func sendReceived(ctx context.Context, fake *yeelighttest.Bulb, events []yeelight.TraceEvent, speed float64) {
+	start := time.Now()
+
+	for _, event := range events {
+		if event.Direction != yeelight.TraceReceive || event.Conn != yeelight.TraceControl {
+			continue
+		}
+
+		if speed > 0 {
+			at := time.Duration(float64(event.Time.Sub(events[0].Time)) / speed)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(start.Add(at))):
+			}
+		}
+
+		data, err := event.Bytes()
+		if err != nil {
+			slog.Warn("skipping invalid trace event", slog.Any("error", err))
+			continue
+		}
+
+		fake.SendRaw(data)
+	}
+}
+
+func logStateChanges(ctx context.Context, bulb *yeelight.Bulb) {
+	for change := range bulb.Subscribe(ctx) {
+		slog.Info("bulb state changed", slog.Any("props", change.Props), slog.String("source", change.Source.String()))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"github.com/cybre/yeelight-controller/internal/config"
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/yeelight"
+)
+
+// maxInterfacePrefixBits is the size of the largest interface network scanned without being configured, since
+// scanning larger ones takes minutes
+const maxInterfacePrefixBits = 22
+
+// discoverBulbs finds bulbs as configured, scanning the network when SSDP finds nothing in auto mode
+func discoverBulbs(ctx context.Context) ([]*yeelight.Bulb, error) {
+	if config.Discovery != config.DiscoveryScan {
+		bulbs, err := yeelight.Discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(bulbs) > 0 || config.Discovery == config.DiscoverySSDP {
+			return bulbs, nil
+		}
+
+		slog.Info("no bulbs answered the SSDP search, scanning the network")
+	}
+
+	prefixes, err := scanPrefixes()
+	if err != nil {
+		return nil, err
+	}
+
+	var bulbs []*yeelight.Bulb
+	for _, prefix := range prefixes {
+		slog.Info("scanning for bulbs", slog.String("prefix", prefix.String()))
+
+		found, err := yeelight.Scan(ctx, prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "scan %s", prefix)
+		}
+
+		bulbs = append(bulbs, found...)
+	}
+
+	return bulbs, nil
+}
+
+// scanPrefixes returns the configured subnet, or else the IPv4 networks of the local interfaces
+func scanPrefixes() ([]netip.Prefix, error) {
+	if config.ScanSubnet.IsValid() {
+		return []netip.Prefix{config.ScanSubnet}, nil
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, errors.Wrapf(err, "list interface addresses")
+	}
+
+	var prefixes []netip.Prefix
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(ipNet.String())
+		if err != nil || !prefix.Addr().Is4() || prefix.Addr().IsLoopback() {
+			continue
+		}
+
+		if prefix.Bits() < maxInterfacePrefixBits {
+			slog.Debug("skipping large interface network, set SCAN_SUBNET to scan it", slog.String("prefix", prefix.String()))
+			continue
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	if len(prefixes) == 0 {
+		return nil, errors.New("no network to scan, set SCAN_SUBNET")
+	}
+
+	return prefixes, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sync"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/yeelight"
+)
+
+// groupShowPalette returns the palette every bulb of the group can show, the palettes being ordered from the
+// richest to the poorest
+func groupShowPalette(group *yeelight.MusicModeGroup) showPalette {
+	palette := paletteColor
+	for _, bulb := range group.Bulbs() {
+		palette = max(palette, bulbShowPalette(bulb.Capabilities()))
+	}
+
+	return palette
+}
+
+// groupColorTemperatureRange returns the color temperatures every bulb of the group with white light supports
+func groupColorTemperatureRange(group *yeelight.MusicModeGroup) yeelight.ColorTemperatureRange {
+	var ctRange yeelight.ColorTemperatureRange
+	for _, bulb := range group.Bulbs() {
+		if !bulb.Capabilities().ColorTemperature {
+			continue
+		}
+
+		bulbRange := bulb.ColorTemperatureRange()
+		if ctRange == (yeelight.ColorTemperatureRange{}) {
+			ctRange = bulbRange
+			continue
+		}
+
+		ctRange.Min = max(ctRange.Min, bulbRange.Min)
+		ctRange.Max = min(ctRange.Max, bulbRange.Max)
+	}
+
+	// Ranges that don't overlap leave a single temperature, which is as close as the bulbs get to each other
+	ctRange.Max = max(ctRange.Max, ctRange.Min)
+
+	return ctRange
+}
+
+// allOff reports whether every bulb of the group is off, which ends the show
+func allOff(group *yeelight.MusicModeGroup) bool {
+	for _, bulb := range group.Bulbs() {
+		if bulb.Power() != yeelight.PowerOff {
+			return false
+		}
+	}
+
+	return true
+}
+
+// turnBackOn turns on the bulbs the restore turned off, leaving the others as they are, and reports whether the show
+// can go on
+func turnBackOn(ctx context.Context, group *yeelight.MusicModeGroup, turnedOff map[netip.AddrPort]bool) bool {
+	var failed, turnedOn int
+	for _, bulb := range group.Bulbs() {
+		if !turnedOff[bulb.Addr()] || bulb.Power() == yeelight.PowerOn {
+			continue
+		}
+
+		if err := bulb.TurnOn(ctx, yeelight.Smooth, 500); err != nil {
+			slog.Error("turn restored bulb back on", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+			failed++
+			continue
+		}
+
+		turnedOn++
+	}
+
+	return failed == 0 || turnedOn > 0
+}
+
+// isPartial reports whether the error is a command of the group failing on some of its bulbs only
+func isPartial(err error) bool {
+	var groupErr *yeelight.GroupError
+
+	return errors.As(err, &groupErr) && groupErr.Partial()
+}
+
+// showError returns the error of a light show command, unless it only failed on some of the bulbs, like ones turned
+// off during the show, which leave the show to the others
+func showError(err error) error {
+	if isPartial(err) {
+		slog.Debug("light show command failed on some bulbs", slog.Any("error", err))
+		return nil
+	}
+
+	return err
+}
+
+// subscribeGroup returns a channel receiving the changes other controllers make to the state of every bulb of the
+// group until the context is done. The changes the show makes are left out, so that they don't hold up the others.
+func subscribeGroup(ctx context.Context, group *yeelight.MusicModeGroup) <-chan yeelight.StateChange {
+	changes := make(chan yeelight.StateChange, len(group.Bulbs()))
+
+	var wg sync.WaitGroup
+	for _, bulb := range group.Bulbs() {
+		wg.Add(1)
+		go func(bulbChanges <-chan yeelight.StateChange) {
+			defer wg.Done()
+
+			for change := range bulbChanges {
+				if change.Source != yeelight.StateSourceExternal {
+					continue
+				}
+
+				select {
+				case changes <- change:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(bulb.Subscribe(ctx))
+	}
+
+	go func() {
+		wg.Wait()
+		close(changes)
+	}()
+
+	return changes
+}
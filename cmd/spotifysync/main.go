@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"log"
 	"log/slog"
 	"math"
+	"net/netip"
 	"os"
 	"os/signal"
 	"slices"
@@ -25,6 +25,17 @@ import (
 
 const (
 	frameRate = 60
+	// delay before entering music mode again after it failed
+	musicModeRetryDelay = 5 * time.Second
+	// how long restoring the bulb's original state may take on exit
+	restoreTimeout = 5 * time.Second
+	// duration of the fade back to the bulb's original state
+	restoreTransition = 2 * time.Second
+	// color temperature used while playback is paused and for quiet acoustic tracks, in Kelvin
+	warmWhiteTemperature = 2700
+	// audio features of tracks that are shown in warm white
+	warmWhiteMinAcousticness = 0.7
+	warmWhiteMaxEnergy       = 0.3
 )
 
 type lightshowState struct {
@@ -71,52 +82,167 @@ func main() {
 		os.Exit(1)
 	}
 
-	bulb, err := getBulb(ctx)
+	// Kept open across re-entries, so that the port is never rebound
+	musicServer, err := yeelight.NewMusicServer(config.MusicModePort, config.MusicModeIP)
 	if err != nil {
-		slog.Error("failed to get bulb", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
+		slog.Error("failed to start music mode server", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
 		os.Exit(1)
 	}
-	if bulb == nil {
-		slog.Error("no bulb found")
-		os.Exit(1)
+	defer musicServer.Close()
+
+	var tracer *yeelight.Tracer
+	if config.TraceFile != "" {
+		traceFile, err := os.OpenFile(config.TraceFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			slog.Error("failed to open trace file", slog.Any("error", err))
+			os.Exit(1)
+		}
+		defer traceFile.Close()
+
+		tracer = yeelight.NewTracer(traceFile)
 	}
 
+	group, initialStates, err := getBulbs(ctx, tracer)
+	if err != nil {
+		slog.Error("failed to get bulb", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
+		os.Exit(1)
+	}
 	defer func() {
-		if err := bulb.Disconnect(); err != nil {
+		if err := group.Disconnect(); err != nil {
 			slog.Warn("failed to disconnect from bulb", slog.Any("error", err))
 		}
 	}()
+	defer func() {
+		// ctx is already cancelled by the time we exit
+		restoreCtx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+
+		for i, bulb := range group.Bulbs() {
+			// Leave music mode first so that the commands get replies and the bulb goes back to its normal rate limit
+			if err := bulb.DisableMusicMode(restoreCtx); err != nil {
+				slog.Warn("disable music mode before restoring bulb state", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+			}
+
+			if err := bulb.Restore(restoreCtx, initialStates[i], restoreTransition); err != nil {
+				slog.Warn("failed to restore bulb state", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+			} else {
+				slog.Info("restored bulb state", slog.String("addr", bulb.Addr().String()))
+			}
+		}
+	}()
+
+	// The show follows the state of the target bulb, the others just mirror it
+	bulb := group.Bulbs()[0]
+
+	registry := yeelight.NewRegistry()
+	go func() {
+		if err := registry.Run(ctx); err != nil {
+			slog.Warn("bulb registry stopped", slog.Any("error", err))
+		}
+	}()
+	go watchRegistry(ctx, registry, group.Bulbs())
+
+	pause := &syncPause{}
 
-	if err := homekit.SetUp(ctx, int(brightnessModifier*100), true, func(power bool) {
+	accessory, err := homekit.SetUp(ctx, int(brightnessModifier*100), true, func(power bool) {
 		var err error
 		if power {
-			err = bulb.TurnOn(ctx, yeelight.Smooth, 500)
+			err = group.TurnOn(ctx, yeelight.Smooth, 500)
 		} else {
-			err = bulb.TurnOff(ctx, yeelight.Smooth, 500)
+			err = group.TurnOff(ctx, yeelight.Smooth, 500)
 		}
 		if err != nil {
 			slog.Error("failed to set power via homekit", slog.Bool("power", power), slog.Any("error", err))
 		}
 	}, func(brightness int) {
 		brightnessModifier = float64(brightness) / 100.0
-	}); err != nil {
+	}, pause.setEnabled)
+	if err != nil {
 		slog.Error("failed to set up homekit", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
+	} else {
+		pause.setAccessory(accessory)
 	}
 
-	if err := bulb.EnableMusicMode(ctx, config.MusicModePort, func(ctx context.Context, bulb *yeelight.MusicModeBulb) error {
+	connectionStates := bulb.SubscribeConnection(ctx)
+
+	for {
+		if err := group.EnableMusicMode(ctx, musicServer, syncPlayback(spotifyClient, pause, group, initialStates)); err != nil {
+			slog.Error("music mode", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(musicModeRetryDelay):
+			}
+		}
+
+		// Music mode fails to be entered while the bulbs are unreachable, so wait for the target bulb to come back
+		if !waitForConnection(ctx, bulb, connectionStates) {
+			return
+		}
+
+		slog.Info("re-entering music mode")
+	}
+}
+
+// syncPlayback returns the music mode callback that follows the Spotify player state and runs the light show on the
+// bulbs of the group, which are restored to the initial states when playback stops
+func syncPlayback(spotifyClient *spotify.Client, pause *syncPause, group *yeelight.Group, initialStates []yeelight.BulbState) func(context.Context, *yeelight.MusicModeGroup) error {
+	return func(ctx context.Context, bulbs *yeelight.MusicModeGroup) error {
 		spotifyTicker := time.NewTicker(1 * time.Second)
 		defer spotifyTicker.Stop()
 
+		stateChanges := subscribeGroup(ctx, bulbs)
+
 		var state *lightshowState
+		var sleepTimerArmed bool
+		// when the show stopped because playback did, zero while it is running
+		var stoppedAt time.Time
+		// whether the bulb is back in its original state since the show stopped
+		var restored bool
+		// the bulbs the restore turned off because they were off to begin with, which the show turns back on
+		turnedOff := make(map[netip.AddrPort]bool)
+
+		restore := func(reason string) {
+			restored = true
+			clear(turnedOff)
+
+			for i, bulb := range group.Bulbs() {
+				if initialStates[i].Power() != yeelight.PowerOn {
+					turnedOff[bulb.Addr()] = true
+				}
+
+				if err := bulb.Restore(ctx, initialStates[i], restoreTransition); err != nil {
+					slog.Error("restore bulb state", slog.String("reason", reason), slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+					continue
+				}
+
+				slog.Info("restored bulb state", slog.String("reason", reason), slog.String("addr", bulb.Addr().String()))
+			}
+		}
 
 		for {
 			select {
 			case <-spotifyTicker.C:
-				if bulb.Power() == yeelight.PowerOff {
+				// A restored bulb may be off because it was off to begin with, which must not keep the show from resuming
+				if !restored && allOff(bulbs) {
 					if state != nil {
 						state.cancel()
 						state = nil
 					}
+					// An expired sleep timer turned the bulb off
+					sleepTimerArmed = false
+					continue
+				}
+
+				if pause.isDisabled() {
+					if state != nil {
+						state.cancel()
+						state = nil
+					}
+
+					if !restored {
+						restore("sync disabled")
+					}
 					continue
 				}
 
@@ -127,6 +253,64 @@ func main() {
 				}
 
 				if playerState.Item == nil || !playerState.Playing {
+					if state != nil {
+						state.cancel()
+						state = nil
+
+						for _, bulb := range bulbs.Bulbs() {
+							if !bulb.Capabilities().ColorTemperature {
+								continue
+							}
+
+							if err := bulb.SetColorTemperature(ctx, warmWhiteTemperature, yeelight.Smooth, 1000); err != nil {
+								slog.Error("set paused color temperature", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+							}
+						}
+
+						if config.SleepTimer > 0 {
+							for _, bulb := range bulbs.Bulbs() {
+								if !bulb.Capabilities().Cron {
+									continue
+								}
+
+								if err := bulb.AddCronJob(ctx, yeelight.CronPowerOff, config.SleepTimer); err != nil {
+									slog.Error("arm sleep timer", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+								} else {
+									slog.Info("sleep timer armed", slog.String("addr", bulb.Addr().String()), slog.Duration("delay", config.SleepTimer))
+									sleepTimerArmed = true
+								}
+							}
+						}
+
+						stoppedAt = time.Now()
+					}
+
+					if config.RestoreAfter > 0 && !stoppedAt.IsZero() && !restored && time.Since(stoppedAt) >= config.RestoreAfter {
+						restore("playback stopped")
+					}
+					continue
+				}
+
+				stoppedAt = time.Time{}
+
+				if sleepTimerArmed {
+					sleepTimerArmed = false
+
+					for _, bulb := range bulbs.Bulbs() {
+						if !bulb.Capabilities().Cron {
+							continue
+						}
+
+						if err := bulb.DeleteCronJob(ctx, yeelight.CronPowerOff); err != nil {
+							slog.Error("cancel sleep timer", slog.String("addr", bulb.Addr().String()), slog.Any("error", err))
+							sleepTimerArmed = true
+						} else {
+							slog.Info("sleep timer cancelled", slog.String("addr", bulb.Addr().String()))
+						}
+					}
+				}
+
+				if pause.paused(playerState.Item.ID) {
 					if state != nil {
 						state.cancel()
 						state = nil
@@ -139,6 +323,13 @@ func main() {
 						state.cancel()
 					}
 
+					if restored && !turnBackOn(ctx, bulbs, turnedOff) {
+						continue
+					}
+
+					restored = false
+					clear(turnedOff)
+
 					trackCtx, cancelTrack := context.WithCancel(ctx)
 					state = &lightshowState{
 						playerState: playerState,
@@ -146,24 +337,50 @@ func main() {
 					}
 
 					go func() {
-						if err := startTrackSync(trackCtx, spotifyClient, playerState, bulb); err != nil {
+						if err := startTrackSync(trackCtx, spotifyClient, playerState, bulbs); err != nil {
 							slog.Error("start track sync", slog.String("stack", err.(*goerrors.Error).ErrorStack()))
 						}
 					}()
 				} else {
 					state.playerState.Progress = playerState.Progress - 300
 				}
+			case change := <-stateChanges:
+				// Someone turned the restored bulb on or off with another controller, which the show must not undo
+				if restored && change.Source == yeelight.StateSourceExternal && slices.Contains(change.Props, "power") {
+					restored = false
+					clear(turnedOff)
+				}
+
+				// Someone took control of the bulb with another controller, so stop overwriting their changes
+				if state == nil || change.Source != yeelight.StateSourceExternal || !isOverride(change.Props) {
+					continue
+				}
+
+				pause.override(state.playerState.Item.ID, change.Props)
+				state.cancel()
+				state = nil
 			case <-ctx.Done():
 				spotifyTicker.Stop()
 				return nil
 			}
 		}
-	}); err != nil {
-		log.Fatal(err.(*goerrors.Error).ErrorStack())
 	}
 }
 
-func startTrackSync(ctx context.Context, spotifyClient *spotify.Client, playerState *spotify.PlayerState, bulb *yeelight.MusicModeBulb) error {
+// waitForConnection blocks until the bulb is connected and reports false if the context is done first
+func waitForConnection(ctx context.Context, bulb *yeelight.Bulb, connectionStates <-chan yeelight.ConnectionState) bool {
+	for bulb.ConnectionState() != yeelight.Connected {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-connectionStates:
+		}
+	}
+
+	return ctx.Err() == nil
+}
+
+func startTrackSync(ctx context.Context, spotifyClient *spotify.Client, playerState *spotify.PlayerState, bulbs *yeelight.MusicModeGroup) error {
 	var audioFeatures *spotify.AudioFeatures
 	var audioAnalysis *spotify.AudioAnalysis
 
@@ -219,14 +436,14 @@ func startTrackSync(ctx context.Context, spotifyClient *spotify.Client, playerSt
 	playMutex.Lock()
 	defer playMutex.Unlock()
 
-	if err := lightShow(ctx, playerState, audioFeatures, audioAnalysis, bulb); err != nil {
+	if err := lightShow(ctx, playerState, audioFeatures, audioAnalysis, bulbs); err != nil {
 		return errors.Wrapf(err, "light show")
 	}
 
 	return nil
 }
 
-func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatures *spotify.AudioFeatures, audioAnalysis *spotify.AudioAnalysis, bulb *yeelight.MusicModeBulb) error {
+func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatures *spotify.AudioFeatures, audioAnalysis *spotify.AudioAnalysis, bulbs *yeelight.MusicModeGroup) error {
 	// Start a ticker to update the progress
 	go func() {
 		ticker := time.NewTicker(10 * time.Millisecond)
@@ -270,12 +487,22 @@ func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatu
 		lowest:  math.Inf(1),
 	})
 
-	previousBarIdx := -1
+	// The bulbs all show the same, so only what every one of them can
+	palette := groupShowPalette(bulbs)
+	ctRange := groupColorTemperatureRange(bulbs)
+
+	// Quiet acoustic tracks get warm white instead of saturated colors
+	warmWhite := palette != paletteBrightness && audioFeatures.Acousticness >= warmWhiteMinAcousticness && audioFeatures.Energy <= warmWhiteMaxEnergy
+	temperature := uint16(warmWhiteTemperature)
+
+	previousBarIdx, previousBeatIdx := -1, -1
 	previousHue, previousSaturation, previousBrightness := 0.0, 0.0, 0.0
 	hue, saturation := 0.0, 0.0
+	// depth of the breathing pulses in the current bar and whether the last pulse dimmed the bulb
+	breathDepth, exhaled := 0, false
 
 	for playerState.Progress < playerState.Item.Duration {
-		if bulb.Power() == yeelight.PowerOff {
+		if allOff(bulbs) {
 			return nil
 		}
 
@@ -331,9 +558,59 @@ func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatu
 
 			saturation = 40 + (scale * 60)
 
+			// White-spectrum bulbs get cooler the louder the bar is
+			if palette == paletteTemperature && !warmWhite {
+				temperature = ctRange.Min + uint16(scale*float64(ctRange.Max-ctRange.Min))
+			}
+
 			previousHue = hue
 			previousSaturation = saturation
 			previousBarIdx = currentBarIdx
+
+			if config.ShowEffect == config.ShowEffectBreathe {
+				barBrightness := (40 + scale*60) * brightnessModifier
+				if err := showError(setShowColor(ctx, bulbs, palette, warmWhite, hue, saturation, temperature, barBrightness)); err != nil {
+					return err
+				}
+
+				breathDepth = int(10 + scale*20)
+				exhaled = false
+			}
+
+			// The ambient light of dual-light models follows the bars, while the main light follows every segment
+			for _, bulb := range bulbs.Bulbs() {
+				if background, ok := bulb.Background(); ok && background.Power() == yeelight.PowerOn && bulb.Capabilities().Supports("bg_start_cf") {
+					barBrightness := (40 + scale*60) * brightnessModifier
+					if err := background.SetHSV(ctx, uint16(hue), uint8(saturation), uint8(barBrightness), yeelight.Smooth, int(bar.Duration*1000)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if config.ShowEffect == config.ShowEffectBreathe {
+			currentBeatIdx := slices.IndexFunc(audioAnalysis.Beats, func(s spotify.Marker) bool {
+				return playerState.Progress >= int(s.Start*1000) && playerState.Progress < int((s.Start+s.Duration)*1000)
+			})
+
+			// Dim on one beat and brighten on the next, which is a single relative command per beat.
+			// Any drift is reset by the absolute color set on the next bar.
+			if currentBeatIdx != -1 && currentBeatIdx != previousBeatIdx {
+				percentage := -breathDepth
+				if exhaled {
+					percentage = breathDepth
+				}
+
+				if err := showError(bulbs.AdjustBrightness(ctx, percentage, int(audioAnalysis.Beats[currentBeatIdx].Duration*1000))); err != nil {
+					return err
+				}
+
+				exhaled = !exhaled
+				previousBeatIdx = currentBeatIdx
+			}
+
+			time.Sleep(time.Duration(1000/frameRate) * time.Millisecond)
+			continue
 		}
 
 		scaledLoudness := calculateNormalizedSegmentLoudness(segment.LoudnessMax, averageTrackLoudness)
@@ -341,7 +618,7 @@ func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatu
 		brightness := (40 + scale*60) * brightnessModifier
 
 		if hue != previousHue || saturation != previousSaturation || brightness != previousBrightness {
-			if err := bulb.SetHSV(ctx, uint16(hue), uint8(saturation), uint8(brightness), yeelight.Smooth, 100); err != nil {
+			if err := showError(setShowColor(ctx, bulbs, palette, warmWhite, hue, saturation, temperature, brightness)); err != nil {
 				return err
 			}
 		}
@@ -356,31 +633,171 @@ func lightShow(ctx context.Context, playerState *spotify.PlayerState, audioFeatu
 	return nil
 }
 
-func getBulb(ctx context.Context) (*yeelight.Bulb, error) {
-	bulbs, err := yeelight.Discover(ctx)
+// getBulbs finds, connects to and turns on the target bulb and the bulbs grouped with it. The returned states are
+// the ones the bulbs had, in the order of the group.
+func getBulbs(ctx context.Context, tracer *yeelight.Tracer) (*yeelight.Group, []yeelight.BulbState, error) {
+	bulbs, err := findBulbs(ctx)
 	if err != nil {
-		return nil, errors.Wrapf(err, "bulb discovery")
+		return nil, nil, err
 	}
 
-	if len(bulbs) == 0 {
-		return nil, nil
+	group := yeelight.NewGroup(bulbs...)
+
+	initialStates := make([]yeelight.BulbState, len(bulbs))
+	for i, bulb := range bulbs {
+		if initialStates[i], err = prepareBulb(ctx, bulb, tracer); err != nil {
+			group.Disconnect()
+			return nil, nil, err
+		}
 	}
 
-	bulb := &bulbs[0]
+	return group, initialStates, nil
+}
+
+// prepareBulb connects to the bulb and turns it on, returning the state it had
+func prepareBulb(ctx context.Context, bulb *yeelight.Bulb, tracer *yeelight.Tracer) (yeelight.BulbState, error) {
+	slog.Info("using bulb", slog.String("id", bulb.ID()), slog.String("name", bulb.Name()), slog.String("model", bulb.Model()), slog.String("addr", bulb.Addr().String()), slog.Bool("color", bulb.Capabilities().Color), slog.Bool("color_temperature", bulb.Capabilities().ColorTemperature))
+
+	if quota := config.CommandQuota.For(bulb.Model()); quota > 0 {
+		bulb.SetCommandQuota(quota)
+	}
+
+	if config.MusicCommandRate > 0 {
+		bulb.SetMusicCommandRate(config.MusicCommandRate)
+	}
+
+	if tracer != nil {
+		bulb.SetTracer(tracer)
+	}
 
 	if err := bulb.Connect(ctx); err != nil {
-		return nil, err
+		return yeelight.BulbState{}, err
 	}
 
-	if err := bulb.TurnOn(ctx, yeelight.Smooth, 500); err != nil {
-		return nil, err
+	caps := bulb.Capabilities()
+
+	// Taken before music mode is enabled, to be restored once we are done with the bulb
+	initialState := bulb.State()
+
+	// Turn the bulb on with the color it already has, in one command. Mono bulbs have no color.
+	if caps.Color || caps.ColorTemperature {
+		if err := bulb.ApplyScene(ctx, bulb.CurrentScene()); err != nil {
+			return yeelight.BulbState{}, err
+		}
+	} else if err := bulb.TurnOn(ctx, yeelight.Smooth, 500); err != nil {
+		return yeelight.BulbState{}, err
 	}
 
 	if err := bulb.DisableMusicMode(ctx); err != nil {
 		slog.Warn("disable music mode (probably not active)", slog.Any("error", err))
 	}
 
-	return bulb, nil
+	return initialState, nil
+}
+
+// watchRegistry points the bulbs to the address they advertise, so that a bulb that was power-cycled or moved is
+// reconnected to without waiting for the reconnection backoff
+func watchRegistry(ctx context.Context, registry *yeelight.Registry, bulbs []*yeelight.Bulb) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-registry.Events():
+			i := slices.IndexFunc(bulbs, func(bulb *yeelight.Bulb) bool {
+				return bulb.ID() != "" && bulb.ID() == event.Bulb.ID()
+			})
+			if i == -1 {
+				slog.Debug("bulb advertisement", slog.String("type", event.Type.String()), slog.String("id", event.Bulb.ID()), slog.String("addr", event.Bulb.Addr().String()))
+				continue
+			}
+
+			slog.Info("bulb advertisement", slog.String("type", event.Type.String()), slog.String("addr", event.Bulb.Addr().String()))
+
+			switch event.Type {
+			case yeelight.BulbAdded, yeelight.BulbUpdated:
+				bulbs[i].Advertised(event.Bulb.Addr())
+			}
+		}
+	}
+}
+
+// findBulbs returns the target bulb followed by the bulbs grouped with it, discovering bulbs unless they all have a
+// static address
+func findBulbs(ctx context.Context) ([]*yeelight.Bulb, error) {
+	selectors := append([]config.BulbSelector{config.TargetBulb}, config.GroupBulbs...)
+
+	var discovered []*yeelight.Bulb
+	discoveryDone := false
+
+	bulbs := make([]*yeelight.Bulb, 0, len(selectors))
+	for _, selector := range selectors {
+		if addr, ok := selector.StaticAddr(); ok {
+			bulbs = append(bulbs, yeelight.NewBulb(addr))
+			continue
+		}
+
+		if !discoveryDone {
+			var err error
+			if discovered, err = discoverBulbs(ctx); err != nil {
+				return nil, errors.Wrapf(err, "bulb discovery")
+			}
+
+			discoveryDone = true
+		}
+
+		bulb, err := config.SelectBulb(selector, discovered)
+		if err != nil {
+			return nil, errors.Wrapf(err, "select bulb")
+		}
+
+		if slices.Contains(bulbs, bulb) {
+			return nil, errors.Errorf("%s selects bulb %s, which is already in the group", selector, bulb.ID())
+		}
+
+		bulbs = append(bulbs, bulb)
+	}
+
+	return bulbs, nil
+}
+
+// showPalette is what the light show can change besides the brightness
+type showPalette uint8
+
+const (
+	paletteColor showPalette = iota
+	paletteTemperature
+	paletteBrightness
+)
+
+func bulbShowPalette(caps yeelight.Capabilities) showPalette {
+	switch {
+	case caps.Color:
+		return paletteColor
+	case caps.ColorTemperature:
+		return paletteTemperature
+	default:
+		return paletteBrightness
+	}
+}
+
+// setShowColor sets the color of a light show frame, or white of the given temperature for quiet acoustic tracks
+// and bulbs without colors
+func setShowColor(ctx context.Context, bulbs *yeelight.MusicModeGroup, palette showPalette, warmWhite bool, hue, saturation float64, temperature uint16, brightness float64) error {
+	switch {
+	case palette == paletteBrightness:
+		return bulbs.SetBrightness(ctx, uint8(brightness), yeelight.Smooth, 100)
+	case warmWhite || palette == paletteTemperature:
+		// set_ct_abx can't set the brightness, so a single step color flow is used instead
+		flow := yeelight.NewFlow(yeelight.CTStep{
+			Duration:    100 * time.Millisecond,
+			Temperature: temperature,
+			Brightness:  uint8(brightness),
+		})
+
+		return bulbs.StartFlow(ctx, flow)
+	default:
+		return bulbs.SetHSV(ctx, uint16(hue), uint8(saturation), uint8(brightness), yeelight.Smooth, 100)
+	}
 }
 
 // Returns a loudness coefficient of a segment relative to the overall loudness of the track
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/config"
+	"github.com/cybre/yeelight-controller/internal/homekit"
+	"github.com/zmb3/spotify/v2"
+)
+
+// syncPause tracks whether the light show is paused, either because someone took control of the bulb with another
+// controller or because sync was switched off in HomeKit
+type syncPause struct {
+	mu        sync.Mutex
+	disabled  bool
+	accessory *homekit.Accessory
+
+	overridden    bool
+	overrideTrack spotify.ID
+	// zero if the override lasts until the next track
+	overrideUntil time.Time
+}
+
+// isOverride reports whether the props changed by another controller mean someone took control of the bulb.
+// Turning it off stops the show anyway and the name and sleep timer don't affect it.
+func isOverride(props []string) bool {
+	return slices.ContainsFunc(props, func(prop string) bool {
+		prop = strings.TrimPrefix(prop, "bg_")
+		return prop != "power" && prop != "name" && prop != "delayoff"
+	})
+}
+
+func (p *syncPause) setAccessory(accessory *homekit.Accessory) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.accessory = accessory
+	p.report()
+}
+
+// override pauses the show of the track until the cooldown passed or the next track starts
+func (p *syncPause) override(track spotify.ID, props []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.overridden = true
+	p.overrideTrack = track
+	p.overrideUntil = time.Time{}
+	if config.OverrideCooldown > 0 {
+		p.overrideUntil = time.Now().Add(config.OverrideCooldown)
+		slog.Info("bulb changed by another controller, pausing light show", slog.Any("props", props), slog.Duration("cooldown", config.OverrideCooldown))
+	} else {
+		slog.Info("bulb changed by another controller, pausing light show until the next track", slog.Any("props", props))
+	}
+
+	p.report()
+}
+
+// setEnabled switches sync on or off, switching it on also ends an override
+func (p *syncPause) setEnabled(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	slog.Info("light show switched via homekit", slog.Bool("enabled", enabled))
+
+	p.disabled = !enabled
+	if enabled {
+		p.overridden = false
+	}
+
+	p.report()
+}
+
+// isDisabled reports whether sync was switched off in HomeKit
+func (p *syncPause) isDisabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.disabled
+}
+
+// paused reports whether the show of the track is paused, ending an override that is over
+func (p *syncPause) paused(track spotify.ID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.overridden && (track != p.overrideTrack || !p.overrideUntil.IsZero() && time.Now().After(p.overrideUntil)) {
+		slog.Info("resuming light show after manual override")
+
+		p.overridden = false
+		p.report()
+	}
+
+	return p.disabled || p.overridden
+}
+
+// report shows the state in HomeKit, the caller must hold the lock
+func (p *syncPause) report() {
+	if p.accessory != nil {
+		p.accessory.SetSyncing(!p.disabled && !p.overridden)
+	}
+}
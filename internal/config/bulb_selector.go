@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// defaultBulbPort is the port Yeelight bulbs listen on for control connections
+const defaultBulbPort = 55443
+
+type BulbSelectorKind string
+
+const (
+	SelectFirst   BulbSelectorKind = ""
+	SelectByID    BulbSelectorKind = "id"
+	SelectByName  BulbSelectorKind = "name"
+	SelectByModel BulbSelectorKind = "model"
+	SelectByAddr  BulbSelectorKind = "addr"
+)
+
+// BulbSelector picks the bulb to control out of the discovered ones
+type BulbSelector struct {
+	Kind  BulbSelectorKind
+	Value string
+	addr  netip.AddrPort
+}
+
+// SelectableBulb is implemented by the bulbs a BulbSelector can choose from
+type SelectableBulb interface {
+	ID() string
+	Name() string
+	Model() string
+	Addr() netip.AddrPort
+}
+
+// ParseBulbSelector parses a selector in the form kind:value, e.g. id:0x0000000012345678, name:Desk,
+// model:stripe or addr:192.168.1.40:55443. An empty string selects the first discovered bulb.
+func ParseBulbSelector(s string) (BulbSelector, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return BulbSelector{}, nil
+	}
+
+	kind, value, ok := strings.Cut(s, ":")
+	if !ok || value == "" {
+		return BulbSelector{}, errors.Errorf("invalid bulb selector %q, expected kind:value", s)
+	}
+
+	selector := BulbSelector{
+		Kind:  BulbSelectorKind(strings.ToLower(kind)),
+		Value: value,
+	}
+
+	switch selector.Kind {
+	case SelectByID, SelectByName, SelectByModel:
+	case SelectByAddr:
+		addr, err := parseBulbAddr(value)
+		if err != nil {
+			return BulbSelector{}, errors.Wrapf(err, "invalid bulb address %q", value)
+		}
+
+		selector.addr = addr
+	default:
+		return BulbSelector{}, errors.Errorf("unknown bulb selector kind %q", kind)
+	}
+
+	return selector, nil
+}
+
+// ParseBulbSelectors parses a comma-separated list of selectors, none of which may be empty
+func ParseBulbSelectors(s string) ([]BulbSelector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	selectors := make([]BulbSelector, 0, len(parts))
+	for _, part := range parts {
+		selector, err := ParseBulbSelector(part)
+		if err != nil {
+			return nil, err
+		}
+
+		if selector.Kind == SelectFirst {
+			return nil, errors.Errorf("empty bulb selector in %q", s)
+		}
+
+		selectors = append(selectors, selector)
+	}
+
+	return selectors, nil
+}
+
+func parseBulbAddr(s string) (netip.AddrPort, error) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort, nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	return netip.AddrPortFrom(addr, defaultBulbPort), nil
+}
+
+func (s BulbSelector) String() string {
+	if s.Kind == SelectFirst {
+		return "first discovered bulb"
+	}
+
+	return fmt.Sprintf("%s:%s", s.Kind, s.Value)
+}
+
+// StaticAddr returns the bulb address if the selector targets a fixed address, in which case discovery can be skipped
+func (s BulbSelector) StaticAddr() (netip.AddrPort, bool) {
+	return s.addr, s.Kind == SelectByAddr
+}
+
+// Matches reports whether the bulb is targeted by the selector
+func (s BulbSelector) Matches(bulb SelectableBulb) bool {
+	switch s.Kind {
+	case SelectFirst:
+		return true
+	case SelectByID:
+		return strings.EqualFold(bulb.ID(), s.Value)
+	case SelectByName:
+		return strings.EqualFold(bulb.Name(), s.Value)
+	case SelectByModel:
+		return strings.EqualFold(bulb.Model(), s.Value)
+	case SelectByAddr:
+		return bulb.Addr() == s.addr
+	default:
+		return false
+	}
+}
+
+// SelectBulb returns the first bulb matched by the selector or an error listing all bulbs if none matches
+func SelectBulb[B SelectableBulb](selector BulbSelector, bulbs []B) (B, error) {
+	for _, bulb := range bulbs {
+		if selector.Matches(bulb) {
+			return bulb, nil
+		}
+	}
+
+	var zero B
+	if len(bulbs) == 0 {
+		return zero, errors.Errorf("no bulb matches %s: no bulbs found", selector)
+	}
+
+	found := make([]string, 0, len(bulbs))
+	for _, bulb := range bulbs {
+		found = append(found, fmt.Sprintf("id:%s name:%q model:%s addr:%s", bulb.ID(), bulb.Name(), bulb.Model(), bulb.Addr()))
+	}
+
+	return zero, errors.Errorf("no bulb matches %s, found: %s", selector, strings.Join(found, "; "))
+}
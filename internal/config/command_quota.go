@@ -0,0 +1,65 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// CommandQuotas override the number of commands per minute sent to bulbs outside of music mode
+type CommandQuotas struct {
+	// Default applies to models without an override of their own (0 uses the library default)
+	Default int
+	// Models holds the overrides by model, e.g. mono or stripe
+	Models map[string]int
+}
+
+// ParseCommandQuotas parses a comma-separated list of quotas, each either a number applying to every model or a
+// model=quota pair, e.g. 60,mono=30,stripe=90
+func ParseCommandQuotas(s string) (CommandQuotas, error) {
+	var quotas CommandQuotas
+	if strings.TrimSpace(s) == "" {
+		return quotas, nil
+	}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+
+		model, value, isModel := strings.Cut(part, "=")
+		if !isModel {
+			value = part
+		}
+
+		quota, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || quota <= 0 {
+			return CommandQuotas{}, errors.Errorf("invalid command quota %q, expected a positive number or model=quota", part)
+		}
+
+		if !isModel {
+			quotas.Default = quota
+			continue
+		}
+
+		model = strings.TrimSpace(model)
+		if model == "" {
+			return CommandQuotas{}, errors.Errorf("invalid command quota %q, expected a positive number or model=quota", part)
+		}
+
+		if quotas.Models == nil {
+			quotas.Models = make(map[string]int)
+		}
+		quotas.Models[model] = quota
+	}
+
+	return quotas, nil
+}
+
+// For returns the quota of the model, 0 if it isn't overridden
+func (q CommandQuotas) For(model string) int {
+	if quota, ok := q.Models[model]; ok {
+		return quota
+	}
+
+	return q.Default
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"flag"
+	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -19,8 +22,54 @@ var (
 	SpotifyClientSecret string
 	// Debug is a flag to enable debug logging
 	Debug bool
+	// TraceFile is the file every message exchanged with the bulb is appended to as JSON lines (unset disables tracing)
+	TraceFile string
 	// MusicModePort is the port to listen on for music mode
 	MusicModePort uint16
+	// MusicModeIP is the IPv4 address bulbs connect to in music mode, for NAT and Docker setups (unset uses the local
+	// address of the route to the bulb)
+	MusicModeIP netip.Addr
+	// TargetBulb selects the bulb to control when several are discovered
+	TargetBulb BulbSelector
+	// GroupBulbs select more bulbs that run the light show along with the target bulb
+	GroupBulbs []BulbSelector
+	// CommandQuota overrides the number of commands per minute sent to the bulbs outside of music mode, for every model
+	// or by model (unset uses the library default)
+	CommandQuota CommandQuotas
+	// MusicCommandRate overrides the number of commands per second sent to the bulb in music mode (0 uses the model default)
+	MusicCommandRate int
+	// SleepTimer turns the bulb off this long after playback stops, in whole minutes (0 disables it)
+	SleepTimer time.Duration
+	// OverrideCooldown pauses the light show this long after the bulb was changed by another controller (0 pauses it until the next track)
+	OverrideCooldown time.Duration
+	// RestoreAfter restores the bulb's original state once playback stopped for this long (0 disables it)
+	RestoreAfter time.Duration
+	// ShowEffect selects how the light show follows the music
+	ShowEffect ShowEffectMode
+	// Discovery selects how bulbs are found when no static address is configured
+	Discovery DiscoveryMode
+	// ScanSubnet is the network probed for bulbs when scanning (unset scans the networks of the local interfaces)
+	ScanSubnet netip.Prefix
+)
+
+type ShowEffectMode string
+
+const (
+	// ShowEffectColor changes the color and brightness with every segment of the track
+	ShowEffectColor ShowEffectMode = "color"
+	// ShowEffectBreathe sets the color once per bar and pulses the brightness on the beats
+	ShowEffectBreathe ShowEffectMode = "breathe"
+)
+
+type DiscoveryMode string
+
+const (
+	// DiscoveryAuto uses SSDP and scans the network when SSDP finds nothing
+	DiscoveryAuto DiscoveryMode = "auto"
+	// DiscoverySSDP only uses SSDP multicast
+	DiscoverySSDP DiscoveryMode = "ssdp"
+	// DiscoveryScan only scans the network, for networks where multicast is blocked
+	DiscoveryScan DiscoveryMode = "scan"
 )
 
 func init() {
@@ -42,6 +91,83 @@ func init() {
 	}
 	MusicModePort = uint16(port)
 
+	if ip := os.Getenv("MUSIC_MODE_IP"); ip != "" {
+		MusicModeIP, err = netip.ParseAddr(ip)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	TargetBulb, err = ParseBulbSelector(os.Getenv("YEELIGHT_BULB"))
+	if err != nil {
+		panic(err)
+	}
+
+	GroupBulbs, err = ParseBulbSelectors(os.Getenv("YEELIGHT_GROUP"))
+	if err != nil {
+		panic(err)
+	}
+
+	CommandQuota, err = ParseCommandQuotas(os.Getenv("YEELIGHT_COMMAND_QUOTA"))
+	if err != nil {
+		panic(err)
+	}
+
+	if rate := os.Getenv("MUSIC_COMMAND_RATE"); rate != "" {
+		MusicCommandRate, err = strconv.Atoi(rate)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if sleepTimer := os.Getenv("SLEEP_TIMER"); sleepTimer != "" {
+		SleepTimer, err = time.ParseDuration(sleepTimer)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if cooldown := os.Getenv("OVERRIDE_COOLDOWN"); cooldown != "" {
+		OverrideCooldown, err = time.ParseDuration(cooldown)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	if restoreAfter := os.Getenv("RESTORE_AFTER"); restoreAfter != "" {
+		RestoreAfter, err = time.ParseDuration(restoreAfter)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	switch effect := ShowEffectMode(os.Getenv("SHOW_EFFECT")); effect {
+	case "", ShowEffectColor:
+		ShowEffect = ShowEffectColor
+	case ShowEffectBreathe:
+		ShowEffect = effect
+	default:
+		panic(fmt.Sprintf("unknown show effect %q", effect))
+	}
+
+	switch discovery := DiscoveryMode(os.Getenv("DISCOVERY")); discovery {
+	case "", DiscoveryAuto:
+		Discovery = DiscoveryAuto
+	case DiscoverySSDP, DiscoveryScan:
+		Discovery = discovery
+	default:
+		panic(fmt.Sprintf("unknown discovery mode %q", discovery))
+	}
+
+	if subnet := os.Getenv("SCAN_SUBNET"); subnet != "" {
+		ScanSubnet, err = netip.ParsePrefix(subnet)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	TraceFile = os.Getenv("TRACE_FILE")
+
 	debugFlag := flag.Bool("debug", false, "enable debug logging")
 	flag.Parse()
 
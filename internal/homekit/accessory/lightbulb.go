@@ -8,6 +8,7 @@ import (
 type SpotifySyncBulb struct {
 	*hapaccessory.A
 	Bulb *service.SpotifySyncBulb
+	Sync *service.SyncSwitch
 }
 
 func NewLightbulb(info hapaccessory.Info) *SpotifySyncBulb {
@@ -17,5 +18,8 @@ func NewLightbulb(info hapaccessory.Info) *SpotifySyncBulb {
 	a.Bulb = service.NewSpotifySyncBulb()
 	a.AddS(a.Bulb.S)
 
+	a.Sync = service.NewSyncSwitch()
+	a.AddS(a.Sync.S)
+
 	return &a
 }
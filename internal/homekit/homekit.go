@@ -10,7 +10,17 @@ import (
 	"github.com/cybre/yeelight-controller/internal/homekit/accessory"
 )
 
-func SetUp(ctx context.Context, intialBrightness int, isOn bool, powerCallback func(bool), brightnessCallback func(int)) error {
+// Accessory lets the daemon report its state to HomeKit
+type Accessory struct {
+	a *accessory.SpotifySyncBulb
+}
+
+// SetSyncing shows whether the light show is running or paused
+func (a *Accessory) SetSyncing(syncing bool) {
+	a.a.Sync.On.SetValue(syncing)
+}
+
+func SetUp(ctx context.Context, intialBrightness int, isOn bool, powerCallback func(bool), brightnessCallback func(int), syncCallback func(bool)) (*Accessory, error) {
 	a := accessory.NewLightbulb(hapaccessory.Info{
 		Name:         "Spotify LED Strip",
 		SerialNumber: "0000002",
@@ -21,16 +31,18 @@ func SetUp(ctx context.Context, intialBrightness int, isOn bool, powerCallback f
 
 	a.Bulb.On.SetValue(isOn)
 	if err := a.Bulb.Brightness.SetValue(intialBrightness); err != nil {
-		return errors.Wrapf(err, "set initial brightness")
+		return nil, errors.Wrapf(err, "set initial brightness")
 	}
+	a.Sync.On.SetValue(true)
 
 	a.Bulb.On.OnValueRemoteUpdate(powerCallback)
 	a.Bulb.Brightness.OnValueRemoteUpdate(brightnessCallback)
+	a.Sync.On.OnValueRemoteUpdate(syncCallback)
 
 	fs := hap.NewFsStore("./homekitdb")
 	server, err := hap.NewServer(fs, a.A)
 	if err != nil {
-		return errors.Wrapf(err, "create hap server")
+		return nil, errors.Wrapf(err, "create hap server")
 	}
 
 	go func() {
@@ -39,5 +51,5 @@ func SetUp(ctx context.Context, intialBrightness int, isOn bool, powerCallback f
 		}
 	}()
 
-	return nil
+	return &Accessory{a: a}, nil
 }
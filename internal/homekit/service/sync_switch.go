@@ -0,0 +1,29 @@
+package service
+
+import (
+	"github.com/brutella/hap/characteristic"
+	hapservice "github.com/brutella/hap/service"
+)
+
+const TypeSwitch = "49"
+
+type SyncSwitch struct {
+	*hapservice.S
+
+	On   *characteristic.On
+	Name *characteristic.Name
+}
+
+func NewSyncSwitch() *SyncSwitch {
+	s := SyncSwitch{}
+	s.S = hapservice.New(TypeSwitch)
+
+	s.On = characteristic.NewOn()
+	s.AddC(s.On.C)
+
+	s.Name = characteristic.NewName()
+	s.Name.SetValue("Spotify Sync")
+	s.AddC(s.Name.C)
+
+	return &s
+}
@@ -0,0 +1,78 @@
+package yeelight
+
+import (
+	"context"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// AdjustAction is the direction set_adjust moves a property in
+type AdjustAction string
+
+const (
+	AdjustIncrease AdjustAction = "increase"
+	AdjustDecrease AdjustAction = "decrease"
+	// AdjustCircle increases the property and wraps around to the minimum once the maximum is reached
+	AdjustCircle AdjustAction = "circle"
+)
+
+// AdjustProp is the property set_adjust changes
+type AdjustProp string
+
+const (
+	AdjustPropBrightness       AdjustProp = "bright"
+	AdjustPropColorTemperature AdjustProp = "ct"
+	// AdjustPropColor can only be adjusted with AdjustCircle
+	AdjustPropColor AdjustProp = "color"
+)
+
+// SetAdjust nudges the brightness, color temperature or color of the bulb without knowing its current value
+func (bb *bulbBase) SetAdjust(ctx context.Context, action AdjustAction, prop AdjustProp) error {
+	return bb.setAdjust(ctx, mainLight, action, prop)
+}
+
+// AdjustBrightness changes the brightness by a percentage (-100 to 100)
+func (bb *bulbBase) AdjustBrightness(ctx context.Context, percentage int, duration int) error {
+	return bb.adjust(ctx, mainLight, "adjust_bright", percentage, duration)
+}
+
+// AdjustColor changes the color by a percentage (-100 to 100)
+func (bb *bulbBase) AdjustColor(ctx context.Context, percentage int, duration int) error {
+	return bb.adjust(ctx, mainLight, "adjust_color", percentage, duration)
+}
+
+// SetAdjust nudges the brightness, color temperature or color of the background light without knowing its current value
+func (bl *BackgroundLight) SetAdjust(ctx context.Context, action AdjustAction, prop AdjustProp) error {
+	return bl.bulb.setAdjust(ctx, backgroundLight, action, prop)
+}
+
+// AdjustBrightness changes the brightness of the background light by a percentage (-100 to 100)
+func (bl *BackgroundLight) AdjustBrightness(ctx context.Context, percentage int, duration int) error {
+	return bl.bulb.adjust(ctx, backgroundLight, "adjust_bright", percentage, duration)
+}
+
+// AdjustColor changes the color of the background light by a percentage (-100 to 100)
+func (bl *BackgroundLight) AdjustColor(ctx context.Context, percentage int, duration int) error {
+	return bl.bulb.adjust(ctx, backgroundLight, "adjust_color", percentage, duration)
+}
+
+func (bb *bulbBase) setAdjust(ctx context.Context, l light, action AdjustAction, prop AdjustProp) error {
+	if prop == AdjustPropColor && action != AdjustCircle {
+		return errors.Wrapf(ErrAdjustInvalid, "%s %s", action, prop)
+	}
+
+	_, err := bb.executeCommand(ctx, l.method("set_adjust"), action, prop)
+
+	return err
+}
+
+// adjust sends one of the adjust_* methods, which all take a percentage and a duration
+func (bb *bulbBase) adjust(ctx context.Context, l light, method string, percentage int, duration int) error {
+	if percentage < -100 || percentage > 100 {
+		return errors.Wrap(ErrPercentageInvalid)
+	}
+
+	_, err := bb.executeCommand(ctx, l.method(method), percentage, duration)
+
+	return err
+}
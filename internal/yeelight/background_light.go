@@ -0,0 +1,64 @@
+package yeelight
+
+import (
+	"context"
+)
+
+// BackgroundLight is the second, ambient light of dual-light models like ceiling lights and the bedside lamp Pro
+type BackgroundLight struct {
+	bulb *bulbBase
+}
+
+// Background returns the background light, if the bulb has one
+func (bb *bulbBase) Background() (*BackgroundLight, bool) {
+	if !bb.HasBackgroundLight() {
+		return nil, false
+	}
+
+	return &BackgroundLight{
+		bulb: bb,
+	}, true
+}
+
+// State returns a snapshot of the current state of the background light
+func (bl *BackgroundLight) State() LightState {
+	return bl.bulb.State().Background()
+}
+
+func (bl *BackgroundLight) Power() PowerStatus {
+	return bl.State().Power()
+}
+
+func (bl *BackgroundLight) TurnOn(ctx context.Context, effect Effect, duration int) error {
+	return bl.bulb.setPower(ctx, backgroundLight, PowerOn, effect, duration)
+}
+
+func (bl *BackgroundLight) TurnOff(ctx context.Context, effect Effect, duration int) error {
+	return bl.bulb.setPower(ctx, backgroundLight, PowerOff, effect, duration)
+}
+
+func (bl *BackgroundLight) Toggle(ctx context.Context, effect Effect, duration int) error {
+	return bl.bulb.toggle(ctx, backgroundLight, effect, duration)
+}
+
+func (bl *BackgroundLight) SetBrightness(ctx context.Context, brightness uint8, effect Effect, duration int) error {
+	return bl.bulb.setBrightness(ctx, backgroundLight, brightness, effect, duration)
+}
+
+func (bl *BackgroundLight) SetRGB(ctx context.Context, r, g, b uint8, effect Effect, duration int) error {
+	return bl.bulb.setRGB(ctx, backgroundLight, r, g, b, effect, duration)
+}
+
+func (bl *BackgroundLight) SetHSV(ctx context.Context, hue uint16, saturation uint8, value uint8, effect Effect, duration int) error {
+	return bl.bulb.setHSV(ctx, backgroundLight, hue, saturation, value, effect, duration)
+}
+
+// StartFlow makes the background light run the color flow on its own
+func (bl *BackgroundLight) StartFlow(ctx context.Context, flow *Flow) error {
+	return bl.bulb.startFlow(ctx, backgroundLight, flow)
+}
+
+// StopFlow stops a running color flow on the background light, leaving it in its current state
+func (bl *BackgroundLight) StopFlow(ctx context.Context) error {
+	return bl.bulb.stopFlow(ctx, backgroundLight)
+}
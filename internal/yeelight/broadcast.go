@@ -0,0 +1,158 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// subscriberBuffer is the number of values a subscriber can fall behind before values are dropped for it
+	subscriberBuffer = 16
+	// dropWarningInterval is how often dropped values are reported at most
+	dropWarningInterval = 10 * time.Second
+)
+
+// coalescer is implemented by values a slow subscriber can afford to miss some of
+type coalescer[T any] interface {
+	// coalesce merges the next value into this one, if the result tells a subscriber all it needs of both
+	coalesce(next T) (T, bool)
+	// droppable reports whether the value is dropped before the others when a subscriber falls behind
+	droppable() bool
+}
+
+// broadcaster fans values out to any number of subscribers without ever blocking the publisher
+type broadcaster[T any] struct {
+	mu          sync.Mutex
+	subscribers map[*subscription[T]]struct{}
+	// number of values dropped since the last warning, and when it was
+	dropped  int
+	warnedAt time.Time
+}
+
+// subscription queues the values published for a subscriber until they are delivered
+type subscription[T any] struct {
+	ch    chan T
+	queue []T
+	ready chan struct{}
+}
+
+// subscribe returns a channel receiving every value published until the context is done, after which it is closed
+func (b *broadcaster[T]) subscribe(ctx context.Context) <-chan T {
+	sub := &subscription[T]{
+		ch:    make(chan T),
+		ready: make(chan struct{}, 1),
+	}
+
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[*subscription[T]]struct{})
+	}
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	go b.deliver(ctx, sub)
+
+	return sub.ch
+}
+
+// deliver sends the values queued for the subscriber until the context is done
+func (b *broadcaster[T]) deliver(ctx context.Context, sub *subscription[T]) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subscribers, sub)
+		b.mu.Unlock()
+
+		close(sub.ch)
+	}()
+
+	for {
+		b.mu.Lock()
+		if len(sub.queue) == 0 {
+			b.mu.Unlock()
+
+			select {
+			case <-sub.ready:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		value := sub.queue[0]
+		sub.queue = slices.Delete(sub.queue, 0, 1)
+		b.mu.Unlock()
+
+		select {
+		case sub.ch <- value:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *broadcaster[T]) publish(value T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subscribers {
+		if !sub.enqueue(value) {
+			b.warnDropped()
+		}
+
+		select {
+		case sub.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// enqueue queues the value for the subscriber and reports whether nothing was lost. A subscriber that fell behind
+// gets the value merged into the last one queued, or else loses the oldest droppable value, or else the oldest one.
+func (sub *subscription[T]) enqueue(value T) bool {
+	if len(sub.queue) < subscriberBuffer {
+		sub.queue = append(sub.queue, value)
+		return true
+	}
+
+	last := len(sub.queue) - 1
+	if c, ok := any(sub.queue[last]).(coalescer[T]); ok {
+		if merged, ok := c.coalesce(value); ok {
+			sub.queue[last] = merged
+			return true
+		}
+	}
+
+	i := slices.IndexFunc(sub.queue, droppable[T])
+	if i < 0 {
+		if droppable(value) {
+			return false
+		}
+
+		i = 0
+	}
+
+	sub.queue = append(slices.Delete(sub.queue, i, i+1), value)
+
+	return false
+}
+
+// warnDropped counts a dropped value, and reports the count unless it did so recently
+func (b *broadcaster[T]) warnDropped() {
+	b.dropped++
+	if time.Since(b.warnedAt) < dropWarningInterval {
+		return
+	}
+
+	slog.Warn("subscriber is too slow, dropped values", slog.Int("dropped", b.dropped))
+	b.dropped = 0
+	b.warnedAt = time.Now()
+}
+
+func droppable[T any](value T) bool {
+	c, ok := any(value).(coalescer[T])
+
+	return ok && c.droppable()
+}
@@ -0,0 +1,122 @@
+package yeelight
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receive returns the values the subscriber receives until none arrives for a while
+func receive[T any](ch <-chan T) []T {
+	var values []T
+	for {
+		select {
+		case value := <-ch:
+			values = append(values, value)
+		case <-time.After(100 * time.Millisecond):
+			return values
+		}
+	}
+}
+
+func TestBroadcasterDeliversInOrder(t *testing.T) {
+	var b broadcaster[int]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := b.subscribe(ctx)
+
+	for i := 0; i < subscriberBuffer; i++ {
+		b.publish(i)
+	}
+
+	got := receive(ch)
+	if len(got) != subscriberBuffer {
+		t.Fatalf("received %d values, want %d", len(got), subscriberBuffer)
+	}
+
+	for i, value := range got {
+		if value != i {
+			t.Fatalf("got %v, want values in the order published", got)
+		}
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after the context is done")
+	}
+}
+
+func TestBroadcasterDropsLocalChangesFirst(t *testing.T) {
+	var b broadcaster[StateChange]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Not read until everything is published, like a subscriber busy with something else
+	ch := b.subscribe(ctx)
+
+	external := func(prop string) StateChange {
+		return StateChange{Props: []string{prop}, Source: StateSourceExternal}
+	}
+	local := func(prop string) StateChange {
+		return StateChange{Props: []string{prop}, Source: StateSourceLocal}
+	}
+
+	b.publish(local("bright"))
+	for i := 0; i < subscriberBuffer-2; i++ {
+		b.publish(local("rgb"))
+		b.publish(external("power"))
+	}
+
+	changes := receive(ch)
+
+	var externals int
+	for _, change := range changes {
+		if change.Source == StateSourceExternal {
+			externals++
+		}
+	}
+
+	if want := subscriberBuffer - 2; externals != want {
+		t.Errorf("received %d external changes, want all %d", externals, want)
+	}
+
+	if len(changes) > subscriberBuffer+1 {
+		t.Errorf("received %d changes, want at most %d", len(changes), subscriberBuffer+1)
+	}
+}
+
+func TestBroadcasterMergesLocalChanges(t *testing.T) {
+	var b broadcaster[StateChange]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := b.subscribe(ctx)
+
+	var state BulbState
+	for i := 0; i < 2*subscriberBuffer; i++ {
+		state.brightness = uint8(i + 1)
+		props := []string{"bright"}
+		if i%2 == 0 {
+			props = []string{"bright", "ct"}
+		}
+
+		b.publish(StateChange{Props: props, Source: StateSourceLocal, State: state})
+	}
+
+	changes := receive(ch)
+	if len(changes) > subscriberBuffer+1 {
+		t.Fatalf("received %d changes, want at most %d", len(changes), subscriberBuffer+1)
+	}
+
+	last := changes[len(changes)-1]
+	if got := last.State.Brightness(); got != 2*subscriberBuffer {
+		t.Errorf("last change has brightness %d, want the last one published, %d", got, 2*subscriberBuffer)
+	}
+
+	if want := []string{"bright", "ct"}; !reflect.DeepEqual(last.Props, want) {
+		t.Errorf("last change has props %v, want %v", last.Props, want)
+	}
+}
@@ -2,16 +2,16 @@ package yeelight
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/netip"
-	"strconv"
-	"strings"
-	"time"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/utils"
 )
 
 type commandError struct {
@@ -37,89 +37,155 @@ type notification struct {
 type Bulb struct {
 	bulbBase
 
-	results            chan commandResult
+	pending *pendingCommands
+	limiter *rateLimiter
+	cancel  context.CancelFunc
+
+	connStateMu      sync.Mutex
+	connectionState  ConnectionState
+	connectionStates broadcaster[ConnectionState]
+	// advertised cuts the reconnection backoff short once the bulb advertised itself again
+	advertised chan struct{}
+
+	musicMu            sync.Mutex
 	musicContextCancel context.CancelFunc
+	// musicCommandRate overrides the commands per second of the model in music mode
+	musicCommandRate int
+
+	tracer atomic.Pointer[Tracer]
 }
 
-func newBulb(addr netip.AddrPort) Bulb {
-	results := make(chan commandResult)
+func newBulb(info *bulbInfo) *Bulb {
+	info.capabilities = newCapabilities(info.model, info.support)
 
-	return Bulb{
+	bulb := &Bulb{
 		bulbBase: bulbBase{
-			bulbInfo: &bulbInfo{
-				addr: addr,
-			},
-			commandCallback: getCommandExecutionCallback(results),
+			bulbInfo: info,
 		},
-		results: results,
+		pending:    newPendingCommands(),
+		limiter:    newRateLimiter(defaultCommandQuota),
+		advertised: make(chan struct{}, 1),
 	}
+	bulb.sendCommand = bulb.send
+
+	return bulb
 }
 
-func (bb *Bulb) Connect(ctx context.Context) error {
-	conn, err := net.Dial("tcp", bb.Addr().String())
+// NewBulb creates a bulb with a known address, for use when discovery is not possible.
+// Its properties are fetched once it is connected.
+func NewBulb(addr netip.AddrPort) *Bulb {
+	return newBulb(&bulbInfo{
+		addr: addr,
+	})
+}
+
+// EnableMusicMode enters music mode, with the bulb connecting to the server, and runs the callback until it returns
+// or music mode is disabled. Music mode is re-entered whenever its connection is lost. Bulbs without music mode run
+// the callback too, with their commands sent over the control connection.
+func (bb *Bulb) EnableMusicMode(ctx context.Context, server *MusicServer, callback func(context.Context, *MusicModeBulb) error) error {
+	musicContext, bulb, err := bb.startMusicMode(ctx, server)
 	if err != nil {
-		return errors.Wrapf(err, "connect to bulb")
+		return err
 	}
+	defer bb.endMusicMode(bulb)
 
-	bb.conn = conn
-
-	bb.listen(ctx)
+	err = callback(musicContext, bulb)
 
-	return nil
+	return errors.Wrapf(err, "music mode callback")
 }
 
-func (bb *Bulb) EnableMusicMode(ctx context.Context, port uint16, callback func(context.Context, *MusicModeBulb) error) error {
-	localAddr := bb.conn.LocalAddr()
-	if localAddr == nil {
-		return errors.New("bulb is not connected")
+// startMusicMode enters music mode and keeps it up until the returned context is done, which happens when
+// music mode is disabled or the parent context is done
+func (bb *Bulb) startMusicMode(ctx context.Context, server *MusicServer) (context.Context, *MusicModeBulb, error) {
+	bulb := newMusicModeBulb(bb)
+
+	musicContext, err := bb.runMusicMode(ctx, server, bulb)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	splitAddr := strings.Split(localAddr.String(), ":")
+	return musicContext, bulb, nil
+}
 
-	if len(splitAddr) != 2 {
-		return errors.New("invalid local address")
-	}
+// runMusicMode enters music mode for the bulb in music mode and starts sending its commands, until the returned
+// context is done. Bulbs without music mode get their commands over the rate limited control connection instead.
+func (bb *Bulb) runMusicMode(ctx context.Context, server *MusicServer, bulb *MusicModeBulb) (context.Context, error) {
+	if !bb.Capabilities().MusicMode {
+		slog.Info("bulb has no music mode, sending its commands over the control connection", slog.String("addr", bb.Addr().String()), slog.String("model", bb.Model()))
 
-	ip := splitAddr[0]
+		musicContext := bb.newMusicContext(ctx)
+		go bulb.pace(musicContext)
 
-	ln, err := net.Listen("tcp", fmt.Sprintf("%s:%d", ip, port))
-	if err != nil {
-		return errors.Wrapf(err, "start music mode listener")
+		return musicContext, nil
 	}
-	defer ln.Close()
 
-	if _, err = bb.executeCommand(ctx, "set_music", 1, ip, port); err != nil {
-		return errors.Wrapf(err, "enable music mode")
+	musicConn, err := bb.enterMusicMode(ctx, server)
+	if err != nil {
+		return nil, err
 	}
 
-	conn, err := ln.Accept()
-	if err != nil {
-		return errors.Wrapf(err, "accept connection from bulb")
+	musicContext := bb.newMusicContext(ctx)
+
+	// A failure of the connection of a previous run is no reason to re-enter music mode
+	select {
+	case <-bulb.broken:
+	default:
 	}
 
+	bulb.attach(musicConn)
+
+	go bulb.pace(musicContext)
+	go bb.superviseMusicMode(musicContext, bulb, server)
+
+	return musicContext, nil
+}
+
+// newMusicContext returns the context of a music mode run, done once music mode is stopped
+func (bb *Bulb) newMusicContext(ctx context.Context) context.Context {
 	musicContext, musicContextCancel := context.WithCancel(ctx)
+
+	bb.musicMu.Lock()
 	bb.musicContextCancel = musicContextCancel
+	bb.musicMu.Unlock()
 
-	bulb := newMusicModeBulb(bb.bulbInfo, conn)
-	defer func() {
-		if bb.musicContextCancel != nil {
-			bb.musicContextCancel()
-			bb.musicContextCancel = nil
-		}
-		if err := bulb.Disconnect(); err != nil {
-			slog.Error("disconnect bulb in music mode", slog.Any("error", err))
-		}
-	}()
+	return musicContext
+}
 
-	err = callback(musicContext, bulb)
+// endMusicMode stops supervising music mode and closes its connection
+func (bb *Bulb) endMusicMode(bulb *MusicModeBulb) {
+	bb.stopMusicMode()
+	if err := bulb.Disconnect(); err != nil {
+		slog.Error("disconnect bulb in music mode", slog.Any("error", err))
+	}
 
-	return errors.Wrapf(err, "music mode callback")
+	stats := bulb.Stats()
+	slog.Info("music mode ended", slog.String("addr", bb.Addr().String()), slog.Uint64("failures", stats.Failures), slog.Uint64("reentries", stats.Reentries), slog.Uint64("fallbackCommands", stats.FallbackCommands), slog.Uint64("sent", stats.Sent), slog.Uint64("dropped", stats.Dropped), slog.Uint64("coalesced", stats.Coalesced))
+}
+
+// enterMusicMode asks the bulb to connect to the server and waits for it to do so
+func (bb *Bulb) enterMusicMode(ctx context.Context, server *MusicServer) (net.Conn, error) {
+	ip, err := bb.musicModeIP(server)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := server.connect(ctx, bb.Addr().Addr(), func() error {
+		_, err := bb.executeCommand(ctx, "set_music", 1, ip.String(), server.Port())
+
+		return errors.Wrapf(err, "enable music mode")
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "accept connection from bulb")
+	}
+
+	return bb.trace(conn, TraceMusic), nil
 }
 
 func (bb *Bulb) DisableMusicMode(ctx context.Context) error {
-	if bb.musicContextCancel != nil {
-		bb.musicContextCancel()
-		bb.musicContextCancel = nil
+	bb.stopMusicMode()
+
+	if !bb.Capabilities().MusicMode {
+		return nil
 	}
 
 	_, err := bb.executeCommand(ctx, "set_music", 0)
@@ -127,175 +193,66 @@ func (bb *Bulb) DisableMusicMode(ctx context.Context) error {
 	return err
 }
 
-func (bb *Bulb) listen(ctx context.Context) {
-	// Poll for props
-	go func() {
-		for {
-			ticker := time.NewTicker(2 * time.Second)
-			defer ticker.Stop()
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				res, err := bb.executeCommand(ctx, "get_prop", "power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name")
-				if err != nil {
-					slog.Error("get bulb props", slog.Any("error", err))
-					continue
-				}
-
-				for i, prop := range res {
-					switch i {
-					case 0:
-						bb.power = PowerStatus(prop)
-					case 1:
-						brightnessInt, err := strconv.ParseUint(prop, 10, 8)
-						if err != nil {
-							slog.Warn("failed to convert brightness to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.brightness = uint8(brightnessInt)
-					case 2:
-						colorModeInt, err := strconv.ParseUint(prop, 10, 8)
-						if err != nil {
-							slog.Warn("failed to convert color mode to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.colorMode = ColorMode(colorModeInt)
-					case 3:
-						colorTemperatureInt, err := strconv.ParseUint(prop, 10, 16)
-						if err != nil {
-							slog.Warn("failed to convert color temperature to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.colorTemperature = uint16(colorTemperatureInt)
-					case 4:
-						rgbInt, err := strconv.ParseUint(prop, 10, 32)
-						if err != nil {
-							slog.Warn("failed to convert RGB to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.rgb = uint(rgbInt)
-					case 5:
-						hueInt, err := strconv.ParseUint(prop, 10, 16)
-						if err != nil {
-							slog.Warn("failed to convert hue to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.hue = uint16(hueInt)
-					case 6:
-						saturationInt, err := strconv.ParseUint(prop, 10, 8)
-						if err != nil {
-							slog.Warn("failed to convert saturation to int", slog.Any("error", err))
-							continue
-						}
-
-						bb.saturation = uint8(saturationInt)
-					case 7:
-						bb.name = prop
-					}
-				}
-			}
-		}
-	}()
-
-	// Listen for prop updates and results
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-
-			buf := make([]byte, 1024)
-			n, err := bb.conn.Read(buf)
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
-
-				panic(err)
-			}
-
-			// parse response
-			resp := string(buf[:n])
-			slog.Debug("received response from bulb", slog.String("response", resp))
-			lines := strings.Split(resp, lineEnding)
-
-			// parse each line
-			for _, line := range lines {
-				if strings.HasPrefix(line, "{\"id\":") {
-					var result commandResult
-					if err := json.Unmarshal([]byte(line), &result); err != nil {
-						slog.Error("failed to unmarshal result", slog.String("json", line), slog.Any("error", err))
-						continue
-					}
-
-					bb.results <- result
-				}
-
-				if strings.HasPrefix(line, "{\"method\":") {
-					var notification notification
-					if err := json.Unmarshal([]byte(line), &notification); err != nil {
-						slog.Error("failed to unmarshal notification", slog.String("json", line), slog.Any("error", err))
-						continue
-					}
-
-					switch notification.Method {
-					case "props":
-						for key, value := range notification.Params {
-							switch key {
-							case "power":
-								bb.power = PowerStatus(value.(string))
-							case "bright":
-								bb.brightness = uint8(value.(float64))
-							case "color_mode":
-								bb.colorMode = ColorMode(int(value.(float64)))
-							case "ct":
-								bb.colorTemperature = uint16(value.(float64))
-							case "rgb":
-								bb.rgb = uint(value.(float64))
-							case "hue":
-								bb.hue = uint16(value.(float64))
-							case "sat":
-								bb.saturation = uint8(value.(float64))
-							case "name":
-								bb.name = value.(string)
-							}
-						}
-					}
-				}
-			}
-		}
-	}()
+// stopMusicMode cancels the context of the running music mode callback, if any
+func (bb *Bulb) stopMusicMode() {
+	bb.musicMu.Lock()
+	defer bb.musicMu.Unlock()
+
+	if bb.musicContextCancel != nil {
+		bb.musicContextCancel()
+		bb.musicContextCancel = nil
+	}
 }
 
-func getCommandExecutionCallback(results <-chan commandResult) func(context.Context, command) ([]string, error) {
-	return func(ctx context.Context, cmd command) ([]string, error) {
-		select {
-		case result := <-results:
-			if result.ID == cmd.ID {
-				if result.Error != nil {
-					return nil, errors.Wrapf(result.Error, "%s (%v)", cmd.Method, cmd.Params)
-				}
-
-				if len(result.Result) == 1 && result.Result[0] == "ok" {
-					return nil, nil
-				}
-
-				return result.Result, nil
-			}
-		case <-time.After(timeout):
-			return nil, errors.New("command timed out")
-		case <-ctx.Done():
-			return nil, errors.Wrapf(ctx.Err(), "execute command %s (%v)", cmd.Method, cmd.Params)
+func (bb *Bulb) refreshProps(ctx context.Context) error {
+	props := mainLightProps
+	if bb.HasBackgroundLight() {
+		props = append(slices.Clone(props), backgroundLightProps...)
+	}
+
+	res, err := bb.executeCommand(ctx, "get_prop", utils.Map(props, func(prop string) interface{} {
+		return prop
+	})...)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]string, len(res))
+	for i, value := range res {
+		if i >= len(props) || value == "" {
+			continue
 		}
 
-		return nil, errors.New("get command result")
+		values[props[i]] = value
 	}
+
+	bb.applyProps(values)
+
+	return nil
+}
+
+// SetCommandQuota overrides the number of commands per minute sent over the control connection
+func (bb *Bulb) SetCommandQuota(perMinute int) {
+	bb.limiter.setQuota(perMinute)
+}
+
+// send writes the command once the rate limit allows it and waits for its result, which is routed back by the reader.
+// Commands superseded by a newer one for the same property while queued return errCoalesced without being sent.
+func (bb *Bulb) send(ctx context.Context, cmd command) ([]string, error) {
+	if bb.ConnectionState() != Connected {
+		return nil, errors.Wrap(ErrDisconnected)
+	}
+
+	if err := bb.limiter.wait(ctx, commandPriority(ctx, cmd), coalescingKey(cmd)); err != nil {
+		return nil, err
+	}
+
+	results := bb.pending.add(cmd.ID)
+
+	if err := bb.writeCommand(bb.getConn(), cmd); err != nil {
+		bb.pending.remove(cmd.ID)
+		return nil, err
+	}
+
+	return bb.pending.wait(ctx, cmd, results)
 }
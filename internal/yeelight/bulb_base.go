@@ -2,132 +2,249 @@ package yeelight
 
 import (
 	"context"
-	"fmt"
 	"log/slog"
 	"net"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/crazy3lf/colorconv"
 	"github.com/cybre/yeelight-controller/internal/errors"
 	"github.com/cybre/yeelight-controller/internal/utils"
 )
 
+// methods that can be executed while the light they target is off
+var powerIndependentMethods = []string{"set_power", "toggle", "set_default", "set_music", "get_prop", "set_scene", "cron_add", "cron_get", "cron_del"}
+
 type bulbBase struct {
 	*bulbInfo
 
+	connMu        sync.RWMutex
 	conn          net.Conn
-	lastCommandID int
+	lastCommandID atomic.Int32
 
-	commandCallback func(context.Context, command) ([]string, error)
+	// sendCommand writes the command to the bulb and returns its result
+	sendCommand func(context.Context, command) ([]string, error)
 }
 
 func (bb *bulbBase) Disconnect() error {
-	return bb.conn.Close()
+	conn := bb.getConn()
+	if conn == nil {
+		return nil
+	}
+
+	return conn.Close()
 }
 
-func (bb *bulbBase) TurnOn(ctx context.Context, effect Effect, duration int) error {
-	_, err := bb.executeCommand(ctx, "set_power", "on", effect, duration)
+func (bb *bulbBase) getConn() net.Conn {
+	bb.connMu.RLock()
+	defer bb.connMu.RUnlock()
 
-	bb.power = PowerOn
+	return bb.conn
+}
 
-	return err
+func (bb *bulbBase) setConn(conn net.Conn) {
+	bb.connMu.Lock()
+	defer bb.connMu.Unlock()
+
+	bb.conn = conn
 }
 
-func (bb *bulbBase) TurnOff(ctx context.Context, effect Effect, duration int) error {
-	_, err := bb.executeCommand(ctx, "set_power", "off", effect, duration)
+// compareAndSwapConn replaces the connection only if it is still the given one and reports whether it did
+func (bb *bulbBase) compareAndSwapConn(old, new net.Conn) bool {
+	bb.connMu.Lock()
+	defer bb.connMu.Unlock()
 
-	bb.power = PowerOff
+	if bb.conn != old {
+		return false
+	}
 
-	return err
-}
+	bb.conn = new
 
-func (bb *bulbBase) Toggle(ctx context.Context, effect Effect, duration int) error {
-	power := bb.power
+	return true
+}
 
-	_, err := bb.executeCommand(ctx, "toggle", effect, duration)
+func (bb *bulbBase) TurnOn(ctx context.Context, effect Effect, duration int) error {
+	return bb.setPower(ctx, mainLight, PowerOn, effect, duration)
+}
 
-	if power == PowerOn {
-		bb.power = PowerOff
-	} else {
-		bb.power = PowerOn
-	}
+func (bb *bulbBase) TurnOff(ctx context.Context, effect Effect, duration int) error {
+	return bb.setPower(ctx, mainLight, PowerOff, effect, duration)
+}
 
-	return err
+func (bb *bulbBase) Toggle(ctx context.Context, effect Effect, duration int) error {
+	return bb.toggle(ctx, mainLight, effect, duration)
 }
 
 func (bb *bulbBase) SetBrightness(ctx context.Context, brightness uint8, effect Effect, duration int) error {
-	if brightness < 1 || brightness > 100 {
-		return errors.Wrap(ErrBrightnessInvalid)
-	}
+	return bb.setBrightness(ctx, mainLight, brightness, effect, duration)
+}
 
-	_, err := bb.executeCommand(ctx, "set_bright", brightness, effect, duration)
+func (bb *bulbBase) SetRGB(ctx context.Context, r, g, b uint8, effect Effect, duration int) error {
+	return bb.setRGB(ctx, mainLight, r, g, b, effect, duration)
+}
 
-	bb.brightness = brightness
+func (bb *bulbBase) SetHSV(ctx context.Context, hue uint16, saturation uint8, value uint8, effect Effect, duration int) error {
+	return bb.setHSV(ctx, mainLight, hue, saturation, value, effect, duration)
+}
 
-	return err
+// StartFlow makes the bulb run the color flow on its own
+func (bb *bulbBase) StartFlow(ctx context.Context, flow *Flow) error {
+	return bb.startFlow(ctx, mainLight, flow)
 }
 
-func (bb *bulbBase) SetRGB(ctx context.Context, r, g, b uint8, effect Effect, duration int) error {
-	rgb := utils.RGBToInt(r, g, b)
+// StopFlow stops a running color flow, leaving the bulb in its current state
+func (bb *bulbBase) StopFlow(ctx context.Context) error {
+	return bb.stopFlow(ctx, mainLight)
+}
+
+func (bb *bulbBase) setPower(ctx context.Context, l light, power PowerStatus, effect Effect, duration int) error {
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		s.light(l).power = power
+	}, l.method("set_power"), power, effect, duration)
+}
+
+func (bb *bulbBase) toggle(ctx context.Context, l light, effect Effect, duration int) error {
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		state := s.light(l)
+		if state.power == PowerOn {
+			state.power = PowerOff
+		} else {
+			state.power = PowerOn
+		}
+	}, l.method("toggle"), effect, duration)
+}
 
-	if _, err := bb.executeCommand(ctx, "set_rgb", rgb, effect, duration); err != nil {
+func (bb *bulbBase) setBrightness(ctx context.Context, l light, brightness uint8, effect Effect, duration int) error {
+	if err := validateBrightness(brightness); err != nil {
 		return err
 	}
 
-	bb.rgb = rgb
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		s.light(l).brightness = brightness
+	}, l.method("set_bright"), brightness, effect, duration)
+}
 
-	return nil
+func (bb *bulbBase) setRGB(ctx context.Context, l light, r, g, b uint8, effect Effect, duration int) error {
+	rgb := utils.RGBToInt(r, g, b)
+
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		state := s.light(l)
+		state.colorMode = ColorModeRGB
+		state.rgb = rgb
+	}, l.method("set_rgb"), rgb, effect, duration)
 }
 
-func (bb *bulbBase) SetHSV(ctx context.Context, hue uint16, saturation uint8, value uint8, effect Effect, duration int) error {
+func (bb *bulbBase) setHSV(ctx context.Context, l light, hue uint16, saturation uint8, value uint8, effect Effect, duration int) error {
+	// The flow would get past the support list of a bulb without color, which supports flows of its brightness.
+	// Background lights have color even on bulbs whose main light doesn't.
+	if l == mainLight && !bb.Capabilities().Color {
+		return errors.Wrap(ErrColorInvalid)
+	}
+
 	red, green, blue, err := colorconv.HSVToRGB(float64(hue), float64(saturation)/100.0, 1)
 	if err != nil {
 		return errors.Wrapf(err, "convert HSV to RGB")
 	}
 
-	rgb := utils.RGBToInt(red, green, blue)
+	// Since set_hsv doesn't actually let you set the brightness (value), we have to use a color flow
+	flow := NewFlow(RGBStep{
+		Duration:   max(time.Duration(duration)*time.Millisecond, minFlowStepDuration),
+		R:          red,
+		G:          green,
+		B:          blue,
+		Brightness: value,
+	})
+	// The flow leaves the bulb in RGB mode, reporting the hue and saturation it had before. Recording ours would
+	// make the next poll look like someone else changed them.
+	return bb.startFlow(ctx, l, flow)
+}
 
-	// Since set_hsv doesn't actually let you set the brightness (value), we have to use start_cf
-	if _, err = bb.executeCommand(ctx, "start_cf", 1, 1, fmt.Sprintf("%d, 1, %d, %d", duration, rgb, value)); err != nil {
-		return err
+func (bb *bulbBase) startFlow(ctx context.Context, l light, flow *Flow) error {
+	params, err := flow.params(bb.ColorTemperatureRange())
+	if err != nil {
+		return errors.Wrapf(err, "invalid flow")
 	}
 
-	bb.hue = hue
-	bb.saturation = saturation
-	bb.brightness = value
-	bb.rgb = rgb
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		flow.apply(s.light(l))
+	}, l.method("start_cf"), params...)
+}
 
-	return nil
+func (bb *bulbBase) stopFlow(ctx context.Context, l light) error {
+	_, err := bb.executeCommand(ctx, l.method("stop_cf"))
+
+	return err
 }
 
 func (bb *bulbBase) executeCommand(ctx context.Context, method string, params ...interface{}) ([]string, error) {
-	if !slices.Contains[[]string](bb.Support(), method) {
+	if !bb.capabilities.Supports(method) {
 		return nil, errors.Errorf("method not supported: %s", method)
 	}
 
-	// Ensure the bulb is on if the command requires it
-	if method != "set_power" && method != "toggle" && method != "set_default" && method != "set_music" && method != "get_prop" {
-		if bb.Power() != PowerOn {
+	// Ensure the light is on if the command requires it
+	l, name := methodLight(method)
+	if !slices.Contains(powerIndependentMethods, name) {
+		state := bb.State()
+		if state.light(l).power != PowerOn {
 			return nil, errors.Wrap(ErrPoweredOff)
 		}
 	}
 
-	command := newCommand(bb.getCommandID(), method, params...)
-	comandText, err := command.String()
+	bb.expect(method)
+
+	return bb.sendCommand(ctx, newCommand(bb.getCommandID(), method, params...))
+}
+
+// executeUpdate executes the command and applies the update to the state once it succeeded. The bulb may report the
+// new values before the result arrives, so they are expected before the command is sent.
+func (bb *bulbBase) executeUpdate(ctx context.Context, update func(*BulbState), method string, params ...interface{}) error {
+	bb.expectUpdate(update)
+
+	if _, err := bb.executeCommand(ctx, method, params...); err != nil {
+		// The newer command that took its place applies its own update
+		if errors.Is(err, errCoalesced) {
+			return nil
+		}
+
+		return err
+	}
+
+	bb.update(update)
+
+	return nil
+}
+
+func (bb *bulbBase) writeCommand(conn net.Conn, cmd command) error {
+	commandText, err := cmd.String()
 	if err != nil {
-		return nil, errors.Wrapf(err, "get command string")
+		return errors.Wrapf(err, "get command string")
+	}
+
+	slog.Debug("executing command", slog.String("command", commandText))
+	if conn == nil {
+		return errors.Wrap(ErrDisconnected)
 	}
 
-	slog.Debug("executing command", slog.String("command", comandText))
-	if _, err = bb.conn.Write([]byte(comandText)); err != nil {
-		return nil, errors.Wrapf(err, "write command to connection")
+	if _, err = conn.Write([]byte(commandText)); err != nil {
+		return errors.Wrapf(err, "write command to connection")
 	}
 
-	return bb.commandCallback(ctx, command)
+	return nil
 }
 
 func (bb *bulbBase) getCommandID() int {
-	bb.lastCommandID++
+	return int(bb.lastCommandID.Add(1))
+}
 
-	return bb.lastCommandID
+// reserveCommandIDs keeps new commands from using IDs up to the given one, e.g. because commands are sent with
+// recorded IDs
+func (bb *bulbBase) reserveCommandIDs(id int) {
+	for {
+		last := bb.lastCommandID.Load()
+		if int(last) >= id || bb.lastCommandID.CompareAndSwap(last, int32(id)) {
+			return
+		}
+	}
 }
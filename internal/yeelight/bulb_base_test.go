@@ -0,0 +1,99 @@
+package yeelight
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+// newTestBulb starts a simulated bulb and returns it with a bulb discovered and connected to it
+func newTestBulb(t *testing.T, opts yeelighttest.Options) (*Bulb, *yeelighttest.Bulb) {
+	t.Helper()
+
+	fake, err := yeelighttest.NewBulb(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+
+	// The simulated bulb answers right away, so discovery needn't wait out its timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	bulbs, err := DiscoverAt(ctx, fake.SSDPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bulbs) != 1 {
+		t.Fatalf("discovered %d bulbs, want 1", len(bulbs))
+	}
+
+	bulb := bulbs[0]
+	if err := bulb.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bulb.Disconnect() })
+
+	return bulb, fake
+}
+
+func newTestMusicServer(t *testing.T) *MusicServer {
+	t.Helper()
+
+	server, err := NewMusicServer(0, netip.MustParseAddr("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	return server
+}
+
+func TestSetHSVShowIsNotAnExternalChange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs for longer than the poll interval")
+	}
+
+	bulb, _ := newTestBulb(t, yeelighttest.Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	external := make(chan []string, 1)
+	go func() {
+		defer close(external)
+
+		for change := range bulb.Subscribe(ctx) {
+			if change.Source == StateSourceExternal {
+				external <- change.Props
+				return
+			}
+		}
+	}()
+
+	err := bulb.EnableMusicMode(ctx, newTestMusicServer(t), func(ctx context.Context, mb *MusicModeBulb) error {
+		// Outlasts a poll, which reports the props the flows left the bulb with
+		deadline := time.Now().Add(pollInterval + 2*time.Second)
+		for i := 0; time.Now().Before(deadline); i++ {
+			if err := mb.SetHSV(ctx, uint16(i*37%360), uint8(40+i%60), uint8(1+i%100), Sudden, 0); err != nil {
+				return err
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if props, ok := <-external; ok {
+		t.Errorf("show reported as an external change of %v", props)
+	}
+}
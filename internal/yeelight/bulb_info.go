@@ -3,7 +3,13 @@ package yeelight
 import (
 	"fmt"
 	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/cybre/yeelight-controller/internal/errors"
 	"github.com/cybre/yeelight-controller/internal/utils"
 )
 
@@ -12,6 +18,18 @@ var (
 	ErrBrightnessInvalid = fmt.Errorf("brightness must be between 1 and 100")
 	ErrHueInvalid        = fmt.Errorf("hue must be between 0 and 359")
 	ErrSaturationInvalid = fmt.Errorf("saturation must be between 0 and 100")
+	ErrDisconnected      = fmt.Errorf("not connected to the bulb")
+	ErrRateLimited       = fmt.Errorf("command rate limit exceeded")
+
+	ErrColorInvalid              = fmt.Errorf("color is not supported by the bulb")
+	ErrColorTemperatureInvalid   = fmt.Errorf("color temperature is not supported by the bulb")
+	ErrPercentageInvalid         = fmt.Errorf("percentage must be between -100 and 100")
+	ErrAdjustInvalid             = fmt.Errorf("color can only be adjusted in a circle")
+	ErrDelayInvalid              = fmt.Errorf("delay must be at least one minute")
+	ErrFlowEmpty                 = fmt.Errorf("flow must have at least one step")
+	ErrFlowRepeatInvalid         = fmt.Errorf("flow repeat count must not be negative")
+	ErrFlowStepDurationInvalid   = fmt.Errorf("flow step duration must be at least 50ms")
+	ErrFlowStepBrightnessInvalid = fmt.Errorf("flow step brightness must be between 0 (unchanged) and 100")
 )
 
 type ColorMode uint8
@@ -36,13 +54,49 @@ const (
 	Smooth Effect = "smooth"
 )
 
-type bulbInfo struct {
-	addr             netip.AddrPort
-	id               string
-	name             string
-	model            string
-	firmwareVersion  string
-	support          []string
+// props requested from the bulb and parsed from SSDP headers, in get_prop order
+var (
+	mainLightProps       = []string{"power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name", "delayoff"}
+	backgroundLightProps = []string{"bg_power", "bg_bright", "bg_lmode", "bg_ct", "bg_rgb", "bg_hue", "bg_sat"}
+)
+
+// light selects which of the lights of a bulb a command or prop refers to
+type light uint8
+
+const (
+	mainLight light = iota
+	backgroundLight
+)
+
+// method returns the name of the method controlling the light
+func (l light) method(name string) string {
+	if l == backgroundLight {
+		return "bg_" + name
+	}
+
+	return name
+}
+
+// prop returns the name of the prop of the light
+func (l light) prop(name string) string {
+	if l == backgroundLight && name == "color_mode" {
+		return "bg_lmode"
+	}
+
+	return l.method(name)
+}
+
+// methodLight returns the light a method controls and the method name without the light prefix
+func methodLight(method string) (light, string) {
+	if name, ok := strings.CutPrefix(method, "bg_"); ok {
+		return backgroundLight, name
+	}
+
+	return mainLight, method
+}
+
+// LightState is the state of a single light, dual-light models have two of them
+type LightState struct {
 	power            PowerStatus
 	brightness       uint8
 	colorMode        ColorMode
@@ -52,50 +106,207 @@ type bulbInfo struct {
 	saturation       uint8
 }
 
-func (bi bulbInfo) Addr() netip.AddrPort {
+func (ls LightState) Power() PowerStatus {
+	return ls.power
+}
+
+func (ls LightState) Brightness() uint8 {
+	return ls.brightness
+}
+
+func (ls LightState) ColorMode() ColorMode {
+	return ls.colorMode
+}
+
+func (ls LightState) ColorTemperature() uint16 {
+	return ls.colorTemperature
+}
+
+func (ls LightState) RGB() (uint8, uint8, uint8) {
+	return utils.IntToRGB(ls.rgb)
+}
+
+func (ls LightState) Hue() uint16 {
+	return ls.hue
+}
+
+func (ls LightState) Saturation() uint8 {
+	return ls.saturation
+}
+
+// bulbInfo is what we know about a bulb. The state is written by the setters, the notification reader and the
+// poller while being read by callers, so it is only accessed through stateMu.
+type bulbInfo struct {
+	id              string
+	model           string
+	firmwareVersion string
+	support         []string
+	capabilities    Capabilities
+
+	stateMu sync.RWMutex
+	addr    netip.AddrPort
+	state   BulbState
+	// expected holds the values of each prop we set recently, until when the bulb may report them
+	expected     map[string]map[string]time.Time
+	stateChanges broadcaster[StateChange]
+}
+
+func (bi *bulbInfo) Addr() netip.AddrPort {
+	bi.stateMu.RLock()
+	defer bi.stateMu.RUnlock()
+
 	return bi.addr
 }
 
-func (bi bulbInfo) ID() string {
+func (bi *bulbInfo) setAddr(addr netip.AddrPort) {
+	bi.stateMu.Lock()
+	bi.addr = addr
+	bi.stateMu.Unlock()
+}
+
+func (bi *bulbInfo) ID() string {
 	return bi.id
 }
 
-func (bi bulbInfo) Model() string {
+func (bi *bulbInfo) Model() string {
 	return bi.model
 }
 
-func (bi bulbInfo) FirmwareVersion() string {
+func (bi *bulbInfo) FirmwareVersion() string {
 	return bi.firmwareVersion
 }
 
-func (bi bulbInfo) Support() []string {
+func (bi *bulbInfo) Support() []string {
 	return bi.support
 }
 
-func (bi bulbInfo) Power() PowerStatus {
-	return bi.power
+func (bi *bulbInfo) Capabilities() Capabilities {
+	return bi.capabilities
 }
 
-func (bi bulbInfo) Brightness() uint8 {
-	return bi.brightness
+// HasBackgroundLight reports whether the bulb has a second, ambient light
+func (bi *bulbInfo) HasBackgroundLight() bool {
+	return bi.capabilities.BackgroundLight
 }
 
-func (bi bulbInfo) ColorMode() ColorMode {
-	return bi.colorMode
+// ColorTemperatureRange returns the color temperatures the bulb supports
+func (bi *bulbInfo) ColorTemperatureRange() ColorTemperatureRange {
+	return bi.capabilities.ColorTemperatureRange
 }
 
-func (bi bulbInfo) ColorTemperature() uint16 {
-	return bi.colorTemperature
+func (bi *bulbInfo) clone() *bulbInfo {
+	return &bulbInfo{
+		id:              bi.id,
+		model:           bi.model,
+		firmwareVersion: bi.firmwareVersion,
+		support:         slices.Clone(bi.support),
+		capabilities:    bi.capabilities,
+		addr:            bi.Addr(),
+		state:           bi.State(),
+	}
 }
 
-func (bi bulbInfo) RGB() (uint8, uint8, uint8) {
-	return utils.IntToRGB(bi.rgb)
-}
+// propValue returns the textual representation of a prop, the inverse of setProp
+func (s BulbState) propValue(prop string) string {
+	l, key := methodLight(prop)
+	if l == backgroundLight && key == "lmode" {
+		key = "color_mode"
+	}
+
+	state := s.light(l)
 
-func (bi bulbInfo) Hue() uint16 {
-	return bi.hue
+	switch key {
+	case "power":
+		return string(state.power)
+	case "bright":
+		return strconv.FormatUint(uint64(state.brightness), 10)
+	case "color_mode":
+		return strconv.FormatUint(uint64(state.colorMode), 10)
+	case "ct":
+		return strconv.FormatUint(uint64(state.colorTemperature), 10)
+	case "rgb":
+		return strconv.FormatUint(uint64(state.rgb), 10)
+	case "hue":
+		return strconv.FormatUint(uint64(state.hue), 10)
+	case "sat":
+		return strconv.FormatUint(uint64(state.saturation), 10)
+	case "name":
+		return s.name
+	case "delayoff":
+		return strconv.Itoa(int(s.delayOff.Minutes()))
+	default:
+		return ""
+	}
 }
 
-func (bi bulbInfo) Saturation() uint8 {
-	return bi.saturation
+// setProp updates a single property from its textual representation as used in SSDP headers and get_prop results
+func (s *BulbState) setProp(key, value string) error {
+	l, key := methodLight(key)
+	if l == backgroundLight && key == "lmode" {
+		key = "color_mode"
+	}
+
+	state := s.light(l)
+
+	switch key {
+	case "power":
+		state.power = PowerStatus(value)
+	case "bright":
+		brightness, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return errors.Wrapf(err, "convert brightness to int")
+		}
+
+		state.brightness = uint8(brightness)
+	case "color_mode":
+		colorMode, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return errors.Wrapf(err, "convert color mode to int")
+		}
+
+		state.colorMode = ColorMode(colorMode)
+	case "ct":
+		colorTemperature, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return errors.Wrapf(err, "convert color temperature to int")
+		}
+
+		state.colorTemperature = uint16(colorTemperature)
+	case "rgb":
+		rgb, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return errors.Wrapf(err, "convert RGB to int")
+		}
+
+		state.rgb = uint(rgb)
+	case "hue":
+		hue, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return errors.Wrapf(err, "convert hue to int")
+		}
+
+		state.hue = uint16(hue)
+	case "sat":
+		saturation, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return errors.Wrapf(err, "convert saturation to int")
+		}
+
+		state.saturation = uint8(saturation)
+	case "name":
+		if l == mainLight {
+			s.name = value
+		}
+	case "delayoff":
+		minutes, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return errors.Wrapf(err, "convert delay off to int")
+		}
+
+		if l == mainLight {
+			s.delayOff = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	return nil
 }
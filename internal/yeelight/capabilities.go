@@ -0,0 +1,125 @@
+package yeelight
+
+// Capabilities is what a bulb can do, derived from its support list and model
+type Capabilities struct {
+	// Color is whether the bulb can show RGB and HSV colors
+	Color bool
+	// ColorTemperature is whether the bulb can show white light of a color temperature in ColorTemperatureRange
+	ColorTemperature      bool
+	ColorTemperatureRange ColorTemperatureRange
+	BackgroundLight       bool
+	Flow                  bool
+	MusicMode             bool
+	Cron                  bool
+	Name                  bool
+
+	// methods is the support list, nil if the bulb didn't advertise one and every method is assumed to be supported
+	methods map[string]struct{}
+}
+
+// modelCapabilities are the capabilities of known models, used for the color temperature range and when a bulb
+// doesn't advertise its support list
+var modelCapabilities = map[string]Capabilities{
+	"color": {
+		Color:                 true,
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 1700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+	"stripe": {
+		Color:                 true,
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 1700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+	"bslamp": {
+		Color:                 true,
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 1700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+	"ceiling": {
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 2700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+	"mono": {
+		Flow: true,
+		Cron: true,
+		Name: true,
+	},
+	"ct_bulb": {
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 2700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+	"lamp": {
+		ColorTemperature:      true,
+		ColorTemperatureRange: ColorTemperatureRange{Min: 2700, Max: 6500},
+		Flow:                  true,
+		MusicMode:             true,
+		Cron:                  true,
+		Name:                  true,
+	},
+}
+
+func newCapabilities(model string, support []string) Capabilities {
+	caps, known := modelCapabilities[model]
+	if !known {
+		// Nothing is known about the bulb, e.g. because it was created from a static address, so let it try anything
+		caps = Capabilities{
+			Color:                 true,
+			ColorTemperature:      true,
+			ColorTemperatureRange: protocolColorTemperatureRange,
+			Flow:                  true,
+			MusicMode:             true,
+			Cron:                  true,
+			Name:                  true,
+		}
+	}
+
+	if support == nil {
+		return caps
+	}
+
+	caps.methods = make(map[string]struct{}, len(support))
+	for _, method := range support {
+		caps.methods[method] = struct{}{}
+	}
+
+	caps.Color = caps.Supports("set_rgb") || caps.Supports("set_hsv")
+	caps.ColorTemperature = caps.Supports("set_ct_abx")
+	caps.BackgroundLight = caps.Supports("bg_set_power")
+	caps.Flow = caps.Supports("start_cf")
+	caps.MusicMode = caps.Supports("set_music")
+	caps.Cron = caps.Supports("cron_add")
+	caps.Name = caps.Supports("set_name")
+
+	return caps
+}
+
+// Supports reports whether the bulb supports the method
+func (c Capabilities) Supports(method string) bool {
+	if c.methods == nil {
+		return true
+	}
+
+	_, ok := c.methods[method]
+
+	return ok
+}
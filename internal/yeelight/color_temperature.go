@@ -0,0 +1,60 @@
+package yeelight
+
+import (
+	"context"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// ColorTemperatureRange is the range of color temperatures a bulb supports, in Kelvin
+type ColorTemperatureRange struct {
+	Min uint16
+	Max uint16
+}
+
+// protocolColorTemperatureRange is the widest range accepted by the protocol, used for unknown models
+var protocolColorTemperatureRange = ColorTemperatureRange{Min: 1700, Max: 6500}
+
+func (r ColorTemperatureRange) Contains(temperature uint16) bool {
+	return temperature >= r.Min && temperature <= r.Max
+}
+
+func (r ColorTemperatureRange) validate(temperature uint16) error {
+	if !r.Contains(temperature) {
+		return errors.Wrapf(ErrColorTemperatureInvalid, "%dK is outside of %dK-%dK", temperature, r.Min, r.Max)
+	}
+
+	return nil
+}
+
+// SetColorTemperature switches the bulb to white light of the given color temperature, in Kelvin
+func (bb *bulbBase) SetColorTemperature(ctx context.Context, temperature uint16, effect Effect, duration int) error {
+	return bb.setColorTemperature(ctx, mainLight, temperature, effect, duration)
+}
+
+// AdjustColorTemperature changes the color temperature by a percentage (-100 to 100) of the supported range
+func (bb *bulbBase) AdjustColorTemperature(ctx context.Context, percentage int, duration int) error {
+	return bb.adjust(ctx, mainLight, "adjust_ct", percentage, duration)
+}
+
+// SetColorTemperature switches the background light to white light of the given color temperature, in Kelvin
+func (bl *BackgroundLight) SetColorTemperature(ctx context.Context, temperature uint16, effect Effect, duration int) error {
+	return bl.bulb.setColorTemperature(ctx, backgroundLight, temperature, effect, duration)
+}
+
+// AdjustColorTemperature changes the color temperature of the background light by a percentage (-100 to 100) of the supported range
+func (bl *BackgroundLight) AdjustColorTemperature(ctx context.Context, percentage int, duration int) error {
+	return bl.bulb.adjust(ctx, backgroundLight, "adjust_ct", percentage, duration)
+}
+
+func (bb *bulbBase) setColorTemperature(ctx context.Context, l light, temperature uint16, effect Effect, duration int) error {
+	if err := bb.ColorTemperatureRange().validate(temperature); err != nil {
+		return err
+	}
+
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		state := s.light(l)
+		state.colorTemperature = temperature
+		state.colorMode = ColorModeTemperature
+	}, l.method("set_ct_abx"), temperature, effect, duration)
+}
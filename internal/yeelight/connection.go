@@ -0,0 +1,324 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// interval at which the bulb props are polled, kept low on the command quota since changes are also notified
+	pollInterval = 10 * time.Second
+	// initial delay between reconnection attempts
+	minReconnectBackoff = 500 * time.Millisecond
+	// upper bound of the delay between reconnection attempts
+	maxReconnectBackoff = 30 * time.Second
+	// number of failed reconnection attempts after which the bulb address is re-resolved via discovery
+	resolveAfterAttempts = 3
+)
+
+type ConnectionState uint8
+
+const (
+	Disconnected ConnectionState = iota
+	Connected
+	Reconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	default:
+		return "unknown"
+	}
+}
+
+func (bb *Bulb) Connect(ctx context.Context) error {
+	conn, err := bb.dial(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "connect to bulb")
+	}
+
+	connCtx, cancel := context.WithCancel(ctx)
+
+	bb.connStateMu.Lock()
+	bb.cancel = cancel
+	bb.connStateMu.Unlock()
+
+	bb.setConn(conn)
+	bb.setConnectionState(Connected)
+
+	go bb.read(connCtx, conn)
+	go bb.poll(connCtx)
+
+	if err := bb.refreshProps(ctx); err != nil {
+		bb.Disconnect()
+		return errors.Wrapf(err, "get bulb props")
+	}
+
+	return nil
+}
+
+// Disconnect closes the control connection for good, without attempting to reconnect
+func (bb *Bulb) Disconnect() error {
+	bb.setConnectionState(Disconnected)
+
+	bb.connStateMu.Lock()
+	cancel := bb.cancel
+	bb.connStateMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	bb.stopMusicMode()
+	bb.pending.failAll()
+
+	return bb.bulbBase.Disconnect()
+}
+
+func (bb *Bulb) ConnectionState() ConnectionState {
+	bb.connStateMu.Lock()
+	defer bb.connStateMu.Unlock()
+
+	return bb.connectionState
+}
+
+// SubscribeConnection returns a channel receiving every connection state change until the context is done
+func (bb *Bulb) SubscribeConnection(ctx context.Context) <-chan ConnectionState {
+	return bb.connectionStates.subscribe(ctx)
+}
+
+func (bb *Bulb) setConnectionState(state ConnectionState) {
+	bb.connStateMu.Lock()
+	changed := bb.connectionState != state
+	bb.connectionState = state
+	bb.connStateMu.Unlock()
+
+	if changed {
+		slog.Debug("bulb connection state changed", slog.String("state", state.String()))
+		bb.connectionStates.publish(state)
+	}
+}
+
+func (bb *Bulb) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", bb.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	return bb.trace(conn, TraceControl), nil
+}
+
+// read routes everything received on the connection and takes care of reconnecting when the connection is lost
+func (bb *Bulb) read(ctx context.Context, conn net.Conn) {
+	for {
+		err := bb.readMessages(conn)
+		if ctx.Err() != nil || bb.ConnectionState() == Disconnected {
+			return
+		}
+
+		slog.Warn("lost connection to bulb", slog.String("addr", bb.Addr().String()), slog.Any("error", err))
+
+		conn.Close()
+		bb.setConnectionState(Reconnecting)
+		bb.stopMusicMode()
+		bb.pending.failAll()
+
+		conn = bb.reconnect(ctx)
+		if conn == nil {
+			return
+		}
+
+		if !bb.resume(conn) {
+			conn.Close()
+			return
+		}
+
+		slog.Info("reconnected to bulb", slog.String("addr", bb.Addr().String()))
+
+		go func() {
+			if err := bb.refreshProps(ctx); err != nil {
+				slog.Error("get bulb props", slog.Any("error", err))
+			}
+		}()
+	}
+}
+
+// resume makes the reconnected connection the current one, unless the bulb was disconnected in the meantime, and
+// reports whether it did
+func (bb *Bulb) resume(conn net.Conn) bool {
+	bb.connStateMu.Lock()
+	if bb.connectionState == Disconnected {
+		bb.connStateMu.Unlock()
+		return false
+	}
+
+	// Set under the lock so that Disconnect closes this connection rather than the lost one
+	bb.setConn(conn)
+	bb.connectionState = Connected
+	bb.connStateMu.Unlock()
+
+	slog.Debug("bulb connection state changed", slog.String("state", Connected.String()))
+	bb.connectionStates.publish(Connected)
+
+	return true
+}
+
+// waitConnected blocks until the bulb is connected and reports false if it was disconnected for good or the context
+// is done first
+func (bb *Bulb) waitConnected(ctx context.Context) bool {
+	subscriptionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	states := bb.SubscribeConnection(subscriptionCtx)
+	for state := bb.ConnectionState(); state != Connected; state = bb.ConnectionState() {
+		if state == Disconnected {
+			return false
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-states:
+		}
+	}
+
+	return true
+}
+
+func (bb *Bulb) readMessages(conn net.Conn) error {
+	decoder := newMessageDecoder(conn)
+
+	for {
+		msg, err := decoder.Decode()
+		if err != nil {
+			return err
+		}
+
+		switch msg.kind {
+		case messageResult:
+			if !bb.pending.resolve(msg.result) {
+				slog.Debug("dropping result of unknown or timed out command", slog.Int("id", msg.result.ID))
+			}
+		case messageNotification:
+			switch msg.notification.Method {
+			case "props":
+				props := make(map[string]string, len(msg.notification.Params))
+				for key, value := range msg.notification.Params {
+					props[key] = propValue(value)
+				}
+
+				bb.applyProps(props)
+			}
+		}
+	}
+}
+
+// reconnect dials the bulb with exponential backoff until it succeeds or the context is done, in which case it returns nil
+func (bb *Bulb) reconnect(ctx context.Context) net.Conn {
+	backoff := minReconnectBackoff
+
+	for attempt := 1; ; attempt++ {
+		// Add up to 50% jitter so that several bulbs don't retry in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff/2)))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		case <-bb.advertised:
+		}
+
+		if attempt%resolveAfterAttempts == 0 {
+			bb.resolve(ctx)
+		}
+
+		conn, err := bb.dial(ctx)
+		if err == nil {
+			return conn
+		}
+
+		slog.Debug("reconnect to bulb", slog.Int("attempt", attempt), slog.Any("error", err))
+
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// resolve looks the bulb up via discovery in case its address changed, e.g. because its DHCP lease expired
+func (bb *Bulb) resolve(ctx context.Context) {
+	if bb.ID() == "" {
+		return
+	}
+
+	discoverCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	bulbs, err := Discover(discoverCtx)
+	if err != nil {
+		slog.Debug("re-resolve bulb address", slog.Any("error", err))
+		return
+	}
+
+	for _, bulb := range bulbs {
+		if bulb.ID() != bb.ID() || bulb.Addr() == bb.Addr() {
+			continue
+		}
+
+		slog.Info("bulb address changed", slog.String("old", bb.Addr().String()), slog.String("new", bulb.Addr().String()))
+		bb.setAddr(bulb.Addr())
+	}
+}
+
+// Advertised tells the bulb it advertised itself at the address, like after being power-cycled or moved to another
+// address, so that the connection is re-established there right away instead of once the backoff expired
+func (bb *Bulb) Advertised(addr netip.AddrPort) {
+	if addr != bb.Addr() {
+		slog.Info("bulb address changed", slog.String("old", bb.Addr().String()), slog.String("new", addr.String()))
+		bb.setAddr(addr)
+
+		// The connection to the old address can take minutes to time out
+		if bb.ConnectionState() == Connected {
+			if conn := bb.getConn(); conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	select {
+	case bb.advertised <- struct{}{}:
+	default:
+	}
+}
+
+func (bb *Bulb) poll(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if bb.ConnectionState() != Connected {
+				continue
+			}
+
+			if err := bb.refreshProps(ctx); err != nil {
+				slog.Error("get bulb props", slog.Any("error", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package yeelight
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// CronType is the kind of job a bulb-side timer runs. Power off is the only one the protocol defines.
+type CronType int
+
+const (
+	CronPowerOff CronType = 0
+)
+
+// CronJob is a timer running on the bulb
+type CronJob struct {
+	Type CronType
+	// Delay is the time left until the job runs, in whole minutes
+	Delay time.Duration
+}
+
+// AddCronJob sets a timer on the bulb that runs the job once the delay, in whole minutes, has passed.
+// Bulbs keep a single job per type, so adding one replaces the previous.
+func (bb *bulbBase) AddCronJob(ctx context.Context, cronType CronType, delay time.Duration) error {
+	minutes := int(delay.Minutes())
+	if minutes < 1 {
+		return errors.Wrap(ErrDelayInvalid)
+	}
+
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		if cronType == CronPowerOff {
+			s.delayOff = time.Duration(minutes) * time.Minute
+		}
+	}, "cron_add", cronType, minutes)
+}
+
+// GetCronJob returns the job of the given type, or nil if none is set
+func (bb *bulbBase) GetCronJob(ctx context.Context, cronType CronType) (*CronJob, error) {
+	res, err := bb.executeCommand(ctx, "cron_get", cronType)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range res {
+		var job struct {
+			Type  CronType `json:"type"`
+			Delay int      `json:"delay"`
+		}
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			return nil, errors.Wrapf(err, "decode cron job %q", value)
+		}
+
+		if job.Type == cronType {
+			return &CronJob{
+				Type:  job.Type,
+				Delay: time.Duration(job.Delay) * time.Minute,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteCronJob cancels the job of the given type
+func (bb *bulbBase) DeleteCronJob(ctx context.Context, cronType CronType) error {
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		if cronType == CronPowerOff {
+			s.delayOff = 0
+		}
+	}, "cron_del", cronType)
+}
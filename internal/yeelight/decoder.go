@@ -0,0 +1,125 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/utils"
+)
+
+type messageKind uint8
+
+const (
+	messageResult messageKind = iota + 1
+	messageNotification
+)
+
+// message is a single line received from the bulb, either the result of a command or a notification
+type message struct {
+	kind         messageKind
+	result       commandResult
+	notification notification
+}
+
+// messageDecoder reads CRLF delimited JSON messages from the bulb, regardless of how they are split across reads
+type messageDecoder struct {
+	r *bufio.Reader
+}
+
+func newMessageDecoder(r io.Reader) *messageDecoder {
+	return &messageDecoder{
+		r: bufio.NewReader(r),
+	}
+}
+
+// Decode returns the next message. Malformed lines are logged and skipped, so the only errors returned
+// are the ones of the underlying reader.
+func (d *messageDecoder) Decode() (message, error) {
+	for {
+		line, err := d.r.ReadBytes('\n')
+		if err != nil {
+			// A trailing line without a line ending is incomplete and therefore discarded
+			return message{}, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		slog.Debug("received message from bulb", slog.String("message", string(line)))
+
+		msg, err := decodeMessage(line)
+		if err != nil {
+			slog.Error("failed to decode message", slog.String("json", string(line)), slog.Any("error", err))
+			continue
+		}
+
+		return msg, nil
+	}
+}
+
+func decodeMessage(line []byte) (message, error) {
+	var raw struct {
+		ID     *int                   `json:"id"`
+		Method string                 `json:"method"`
+		Result []json.RawMessage      `json:"result"`
+		Error  *commandError          `json:"error"`
+		Params map[string]interface{} `json:"params"`
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return message{}, err
+	}
+
+	switch {
+	case raw.Method != "":
+		return message{
+			kind: messageNotification,
+			notification: notification{
+				Method: raw.Method,
+				Params: raw.Params,
+			},
+		}, nil
+	case raw.ID != nil:
+		return message{
+			kind: messageResult,
+			result: commandResult{
+				ID:     *raw.ID,
+				Result: utils.Map(raw.Result, resultValue),
+				Error:  raw.Error,
+			},
+		}, nil
+	default:
+		return message{}, errors.New("message is neither a result nor a notification")
+	}
+}
+
+// resultValue returns strings as they are and any other value, like the objects of cron_get, as JSON
+func resultValue(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+
+	return string(value)
+}
+
+// propValue converts a notification param to the textual form used by get_prop results
+func propValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
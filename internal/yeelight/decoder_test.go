@@ -0,0 +1,183 @@
+package yeelight
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkReader returns its chunks one read at a time, the way they arrive from the connection
+type chunkReader struct {
+	chunks [][]byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunks) > 0 && len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+	}
+
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+
+	return n, nil
+}
+
+// decodeAll decodes messages until the reader fails, which is the only error Decode returns
+func decodeAll(t *testing.T, r io.Reader) []message {
+	t.Helper()
+
+	var messages []message
+	decoder := newMessageDecoder(r)
+	for {
+		msg, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return messages
+		}
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+
+		messages = append(messages, msg)
+	}
+}
+
+func resultMessage(id int, result ...string) message {
+	return message{kind: messageResult, result: commandResult{ID: id, Result: result}}
+}
+
+func propsMessage(params map[string]interface{}) message {
+	return message{kind: messageNotification, notification: notification{Method: "props", Params: params}}
+}
+
+func TestMessageDecoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   []message
+	}{
+		{
+			name:   "result",
+			stream: "{\"id\":1,\"result\":[\"ok\"]}\r\n",
+			want:   []message{resultMessage(1, "ok")},
+		},
+		{
+			name:   "result before id",
+			stream: "{\"result\":[\"on\",\"100\"],\"id\":2}\r\n",
+			want:   []message{resultMessage(2, "on", "100")},
+		},
+		{
+			name:   "error",
+			stream: "{\"id\":3,\"error\":{\"code\":-1,\"message\":\"unsupported method\"}}\r\n",
+			want: []message{{
+				kind:   messageResult,
+				result: commandResult{ID: 3, Result: []string{}, Error: &commandError{Code: -1, Message: "unsupported method"}},
+			}},
+		},
+		{
+			name:   "error before id",
+			stream: "{\"error\":{\"message\":\"client quota exceeded\",\"code\":-5000},\"id\":4}\r\n",
+			want: []message{{
+				kind:   messageResult,
+				result: commandResult{ID: 4, Result: []string{}, Error: &commandError{Code: -5000, Message: "client quota exceeded"}},
+			}},
+		},
+		{
+			name:   "notification",
+			stream: "{\"method\":\"props\",\"params\":{\"power\":\"on\",\"bright\":10}}\r\n",
+			want:   []message{propsMessage(map[string]interface{}{"power": "on", "bright": json.Number("10")})},
+		},
+		{
+			name:   "params before method",
+			stream: "{\"params\":{\"ct\":4000},\"method\":\"props\"}\r\n",
+			want:   []message{propsMessage(map[string]interface{}{"ct": json.Number("4000")})},
+		},
+		{
+			name:   "non-string result values",
+			stream: "{\"id\":5,\"result\":[{\"type\":0,\"delay\":15,\"mix\":0}]}\r\n",
+			want:   []message{resultMessage(5, `{"type":0,"delay":15,"mix":0}`)},
+		},
+		{
+			name:   "several messages in a line of reads",
+			stream: "{\"id\":6,\"result\":[\"ok\"]}\r\n{\"method\":\"props\",\"params\":{\"power\":\"off\"}}\r\n{\"id\":7,\"result\":[\"ok\"]}\r\n",
+			want: []message{
+				resultMessage(6, "ok"),
+				propsMessage(map[string]interface{}{"power": "off"}),
+				resultMessage(7, "ok"),
+			},
+		},
+		{
+			name:   "bare line feeds and blank lines",
+			stream: "\r\n{\"id\":8,\"result\":[\"ok\"]}\n\n  \r\n{\"id\":9,\"result\":[\"ok\"]}\n",
+			want:   []message{resultMessage(8, "ok"), resultMessage(9, "ok")},
+		},
+		{
+			name:   "trailing partial line",
+			stream: "{\"id\":10,\"result\":[\"ok\"]}\r\n{\"id\":11,\"res",
+			want:   []message{resultMessage(10, "ok")},
+		},
+		{
+			name:   "trailing complete message without line ending",
+			stream: "{\"id\":12,\"result\":[\"ok\"]}",
+		},
+		{
+			name:   "malformed lines",
+			stream: "garbage\r\n{\"id\":13,\"result\":[\"ok\"]}\r\n{\"id\":\r\n[]\r\n{}\r\n{\"id\":\"14\",\"result\":[]}\r\n{\"method\":\"props\",\"params\":{\"power\":\"on\"}}\r\n",
+			want: []message{
+				resultMessage(13, "ok"),
+				propsMessage(map[string]interface{}{"power": "on"}),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := []byte(tt.stream)
+
+			// Split in two at every offset, which covers a message split anywhere, even within a CRLF
+			for i := 0; i <= len(stream); i++ {
+				got := decodeAll(t, &chunkReader{chunks: [][]byte{
+					append([]byte(nil), stream[:i]...),
+					append([]byte(nil), stream[i:]...),
+				}})
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("split at %d: got %+v, want %+v", i, got, tt.want)
+				}
+			}
+
+			got := decodeAll(t, iotest.OneByteReader(&chunkReader{chunks: [][]byte{stream}}))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("one byte at a time: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageDecoderReturnsReaderErrors(t *testing.T) {
+	readErr := errors.New("connection reset")
+
+	decoder := newMessageDecoder(io.MultiReader(
+		&chunkReader{chunks: [][]byte{[]byte("{\"id\":1,\"result\":[\"ok\"]}\r\n{\"id\":2")}},
+		iotest.ErrReader(readErr),
+	))
+
+	msg, err := decoder.Decode()
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+
+	if want := resultMessage(1, "ok"); !reflect.DeepEqual(msg, want) {
+		t.Fatalf("got %+v, want %+v", msg, want)
+	}
+
+	if _, err := decoder.Decode(); !errors.Is(err, readErr) {
+		t.Fatalf("got error %v, want %v", err, readErr)
+	}
+}
@@ -0,0 +1,214 @@
+package yeelight
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/utils"
+)
+
+const (
+	// shortest duration the bulb accepts for a flow step
+	minFlowStepDuration = 50 * time.Millisecond
+)
+
+// FlowAction is what the bulb does once a flow ends
+type FlowAction uint8
+
+const (
+	// FlowRecover restores the state from before the flow started
+	FlowRecover FlowAction = iota
+	// FlowStay keeps the state of the last step
+	FlowStay
+	// FlowTurnOff turns the bulb off
+	FlowTurnOff
+)
+
+type flowMode uint8
+
+const (
+	flowModeColor       flowMode = 1
+	flowModeTemperature flowMode = 2
+	flowModeSleep       flowMode = 7
+)
+
+// FlowStep is a single state change of a color flow
+type FlowStep interface {
+	tuple() (duration time.Duration, mode flowMode, value uint, brightness uint8)
+	validate(ctRange ColorTemperatureRange) error
+}
+
+// RGBStep changes the color. A zero Brightness leaves the brightness unchanged.
+type RGBStep struct {
+	Duration   time.Duration
+	R, G, B    uint8
+	Brightness uint8
+}
+
+func (s RGBStep) tuple() (time.Duration, flowMode, uint, uint8) {
+	return s.Duration, flowModeColor, utils.RGBToInt(s.R, s.G, s.B), s.Brightness
+}
+
+func (s RGBStep) validate(ColorTemperatureRange) error {
+	return validateFlowStep(s.Duration, s.Brightness)
+}
+
+// CTStep changes the color temperature, in Kelvin. A zero Brightness leaves the brightness unchanged.
+type CTStep struct {
+	Duration    time.Duration
+	Temperature uint16
+	Brightness  uint8
+}
+
+func (s CTStep) tuple() (time.Duration, flowMode, uint, uint8) {
+	return s.Duration, flowModeTemperature, uint(s.Temperature), s.Brightness
+}
+
+func (s CTStep) validate(ctRange ColorTemperatureRange) error {
+	if err := ctRange.validate(s.Temperature); err != nil {
+		return err
+	}
+
+	return validateFlowStep(s.Duration, s.Brightness)
+}
+
+// SleepStep keeps the current state for its duration
+type SleepStep struct {
+	Duration time.Duration
+}
+
+func (s SleepStep) tuple() (time.Duration, flowMode, uint, uint8) {
+	return s.Duration, flowModeSleep, 0, 0
+}
+
+func (s SleepStep) validate(ColorTemperatureRange) error {
+	return validateFlowStep(s.Duration, 0)
+}
+
+func validateFlowStep(duration time.Duration, brightness uint8) error {
+	if duration < minFlowStepDuration {
+		return errors.Wrap(ErrFlowStepDurationInvalid)
+	}
+
+	if brightness > 100 {
+		return errors.Wrap(ErrFlowStepBrightnessInvalid)
+	}
+
+	return nil
+}
+
+// Flow is a sequence of steps the bulb runs on its own, started with StartFlow
+type Flow struct {
+	steps  []FlowStep
+	repeat int
+	action FlowAction
+}
+
+// NewFlow creates a flow that runs the steps once and then keeps the state of the last one
+func NewFlow(steps ...FlowStep) *Flow {
+	return &Flow{
+		steps:  steps,
+		repeat: 1,
+		action: FlowStay,
+	}
+}
+
+// Then appends a step
+func (f *Flow) Then(step FlowStep) *Flow {
+	f.steps = append(f.steps, step)
+
+	return f
+}
+
+// Repeat sets how many times the steps are run, 0 meaning until the flow is stopped
+func (f *Flow) Repeat(times int) *Flow {
+	f.repeat = times
+
+	return f
+}
+
+// Finally sets what the bulb does once the flow ends
+func (f *Flow) Finally(action FlowAction) *Flow {
+	f.action = action
+
+	return f
+}
+
+func (f *Flow) validate(ctRange ColorTemperatureRange) error {
+	if len(f.steps) == 0 {
+		return errors.Wrap(ErrFlowEmpty)
+	}
+
+	if f.repeat < 0 {
+		return errors.Wrap(ErrFlowRepeatInvalid)
+	}
+
+	for i, step := range f.steps {
+		if err := step.validate(ctRange); err != nil {
+			return errors.Wrapf(err, "flow step %d", i)
+		}
+	}
+
+	return nil
+}
+
+// apply updates the state to the one the bulb is in once the flow ended. Flows that run until stopped have no end.
+func (f *Flow) apply(state *LightState) {
+	if f.repeat == 0 {
+		return
+	}
+
+	switch f.action {
+	case FlowStay:
+		for _, step := range f.steps {
+			_, mode, value, brightness := step.tuple()
+
+			switch mode {
+			case flowModeColor:
+				state.colorMode = ColorModeRGB
+				state.rgb = value
+			case flowModeTemperature:
+				state.colorMode = ColorModeTemperature
+				state.colorTemperature = uint16(value)
+			case flowModeSleep:
+				continue
+			}
+
+			if brightness > 0 {
+				state.brightness = brightness
+			}
+		}
+	case FlowTurnOff:
+		state.power = PowerOff
+	}
+}
+
+// params returns the start_cf parameters: the number of state changes, the end action and the flow expression.
+// Color temperature steps are validated against the range of the bulb the flow is meant for.
+func (f *Flow) params(ctRange ColorTemperatureRange) ([]interface{}, error) {
+	if err := f.validate(ctRange); err != nil {
+		return nil, err
+	}
+
+	tuples := make([]string, 0, len(f.steps))
+	for _, step := range f.steps {
+		duration, mode, value, brightness := step.tuple()
+
+		// -1 leaves the brightness unchanged, sleep steps have no brightness at all
+		brightnessParam := int(brightness)
+		if brightness == 0 && mode != flowModeSleep {
+			brightnessParam = -1
+		}
+
+		tuples = append(tuples, strings.Join([]string{
+			strconv.FormatInt(duration.Milliseconds(), 10),
+			strconv.Itoa(int(mode)),
+			strconv.FormatUint(uint64(value), 10),
+			strconv.Itoa(brightnessParam),
+		}, ", "))
+	}
+
+	return []interface{}{f.repeat * len(f.steps), f.action, strings.Join(tuples, ", ")}, nil
+}
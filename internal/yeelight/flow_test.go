@@ -0,0 +1,132 @@
+package yeelight
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFlowCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		flow *Flow
+		want string
+	}{
+		{
+			name: "rgb step",
+			flow: NewFlow(RGBStep{Duration: 500 * time.Millisecond, R: 255, G: 0, B: 0, Brightness: 80}),
+			want: `{"id":1,"method":"start_cf","params":[1,1,"500, 1, 16711680, 80"]}`,
+		},
+		{
+			name: "ct step",
+			flow: NewFlow(CTStep{Duration: time.Second, Temperature: 2700, Brightness: 100}),
+			want: `{"id":1,"method":"start_cf","params":[1,1,"1000, 2, 2700, 100"]}`,
+		},
+		{
+			name: "sleep step",
+			flow: NewFlow(SleepStep{Duration: 2 * time.Second}),
+			want: `{"id":1,"method":"start_cf","params":[1,1,"2000, 7, 0, 0"]}`,
+		},
+		{
+			name: "unchanged brightness",
+			flow: NewFlow(RGBStep{Duration: 50 * time.Millisecond, R: 0, G: 0, B: 255}, CTStep{Duration: 50 * time.Millisecond, Temperature: 6500}),
+			want: `{"id":1,"method":"start_cf","params":[2,1,"50, 1, 255, -1, 50, 2, 6500, -1"]}`,
+		},
+		{
+			name: "repeated",
+			flow: NewFlow(RGBStep{Duration: 100 * time.Millisecond, R: 0, G: 255, B: 0, Brightness: 1}).
+				Then(SleepStep{Duration: 100 * time.Millisecond}).
+				Then(CTStep{Duration: 100 * time.Millisecond, Temperature: 4000, Brightness: 50}).
+				Repeat(4),
+			want: `{"id":1,"method":"start_cf","params":[12,1,"100, 1, 65280, 1, 100, 7, 0, 0, 100, 2, 4000, 50"]}`,
+		},
+		{
+			name: "until stopped",
+			flow: NewFlow(SleepStep{Duration: time.Second}).Repeat(0),
+			want: `{"id":1,"method":"start_cf","params":[0,1,"1000, 7, 0, 0"]}`,
+		},
+		{
+			name: "recover",
+			flow: NewFlow(SleepStep{Duration: time.Second}).Finally(FlowRecover),
+			want: `{"id":1,"method":"start_cf","params":[1,0,"1000, 7, 0, 0"]}`,
+		},
+		{
+			name: "turn off",
+			flow: NewFlow(SleepStep{Duration: time.Second}).Finally(FlowTurnOff),
+			want: `{"id":1,"method":"start_cf","params":[1,2,"1000, 7, 0, 0"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := tt.flow.params(protocolColorTemperatureRange)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := newCommand(1, "start_cf", params...)
+			got, err := cmd.String()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := tt.want + "\r\n"; got != want {
+				t.Fatalf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFlowValidation(t *testing.T) {
+	ctRange := ColorTemperatureRange{Min: 2700, Max: 6500}
+
+	tests := []struct {
+		name string
+		flow *Flow
+		want error
+	}{
+		{
+			name: "no steps",
+			flow: NewFlow(),
+			want: ErrFlowEmpty,
+		},
+		{
+			name: "negative repeat",
+			flow: NewFlow(SleepStep{Duration: time.Second}).Repeat(-1),
+			want: ErrFlowRepeatInvalid,
+		},
+		{
+			name: "short step",
+			flow: NewFlow(RGBStep{Duration: 49 * time.Millisecond, R: 255}),
+			want: ErrFlowStepDurationInvalid,
+		},
+		{
+			name: "short sleep",
+			flow: NewFlow(SleepStep{Duration: 0}),
+			want: ErrFlowStepDurationInvalid,
+		},
+		{
+			name: "brightness above 100",
+			flow: NewFlow(CTStep{Duration: time.Second, Temperature: 4000, Brightness: 101}),
+			want: ErrFlowStepBrightnessInvalid,
+		},
+		{
+			name: "color temperature below the range",
+			flow: NewFlow(SleepStep{Duration: time.Second}, CTStep{Duration: time.Second, Temperature: 1700}),
+			want: ErrColorTemperatureInvalid,
+		},
+		{
+			name: "color temperature above the range",
+			flow: NewFlow(CTStep{Duration: time.Second, Temperature: 6501}),
+			want: ErrColorTemperatureInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.flow.params(ctRange); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
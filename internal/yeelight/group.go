@@ -0,0 +1,334 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// groupMember is a bulb commands of a group are fanned out to
+type groupMember interface {
+	Addr() netip.AddrPort
+}
+
+// GroupError is returned when a command failed on some or all bulbs of a group
+type GroupError struct {
+	// Errors are the errors of the bulbs the command failed on, by bulb address
+	Errors map[netip.AddrPort]error
+	// Total is the number of bulbs the command was sent to
+	Total int
+}
+
+func (e *GroupError) Error() string {
+	addrs := make([]netip.AddrPort, 0, len(e.Errors))
+	for addr := range e.Errors {
+		addrs = append(addrs, addr)
+	}
+
+	slices.SortFunc(addrs, func(a, b netip.AddrPort) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	failures := make([]string, len(addrs))
+	for i, addr := range addrs {
+		failures[i] = fmt.Sprintf("%s: %s", addr, e.Errors[addr])
+	}
+
+	return fmt.Sprintf("%d of %d bulbs failed: %s", len(e.Errors), e.Total, strings.Join(failures, "; "))
+}
+
+func (e *GroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// Partial reports whether the command succeeded on some of the bulbs
+func (e *GroupError) Partial() bool {
+	return len(e.Errors) < e.Total
+}
+
+// fanOut runs the function for every bulb concurrently and collects the errors in a GroupError
+func fanOut[B groupMember](bulbs []B, fn func(B) error) error {
+	return fanOutIndexed(bulbs, func(_ int, bulb B) error {
+		return fn(bulb)
+	})
+}
+
+// fanOutIndexed is fanOut passing the index of the bulb as well, since the same bulb may be in the group twice
+func fanOutIndexed[B groupMember](bulbs []B, fn func(int, B) error) error {
+	errs := make([]error, len(bulbs))
+
+	var wg sync.WaitGroup
+	for i, bulb := range bulbs {
+		wg.Add(1)
+		go func(i int, bulb B) {
+			defer wg.Done()
+
+			errs[i] = fn(i, bulb)
+		}(i, bulb)
+	}
+	wg.Wait()
+
+	return newGroupError(bulbs, errs)
+}
+
+// newGroupError returns a GroupError for the bulbs whose error isn't nil, or nil if there are none
+func newGroupError[B groupMember](bulbs []B, errs []error) error {
+	groupErr := &GroupError{
+		Errors: make(map[netip.AddrPort]error),
+		Total:  len(bulbs),
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			groupErr.Errors[bulbs[i].Addr()] = err
+		}
+	}
+
+	if len(groupErr.Errors) == 0 {
+		return nil
+	}
+
+	return errors.Wrap(groupErr)
+}
+
+// groupBase implements the commands shared by groups of bulbs in and out of music mode
+type groupBase struct {
+	members []*bulbBase
+}
+
+func (gb *groupBase) TurnOn(ctx context.Context, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.TurnOn(ctx, effect, duration)
+	})
+}
+
+func (gb *groupBase) TurnOff(ctx context.Context, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.TurnOff(ctx, effect, duration)
+	})
+}
+
+func (gb *groupBase) SetBrightness(ctx context.Context, brightness uint8, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.SetBrightness(ctx, brightness, effect, duration)
+	})
+}
+
+func (gb *groupBase) AdjustBrightness(ctx context.Context, percentage int, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.AdjustBrightness(ctx, percentage, duration)
+	})
+}
+
+func (gb *groupBase) SetRGB(ctx context.Context, r, g, b uint8, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.SetRGB(ctx, r, g, b, effect, duration)
+	})
+}
+
+func (gb *groupBase) SetHSV(ctx context.Context, hue uint16, saturation uint8, value uint8, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.SetHSV(ctx, hue, saturation, value, effect, duration)
+	})
+}
+
+func (gb *groupBase) SetColorTemperature(ctx context.Context, temperature uint16, effect Effect, duration int) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.SetColorTemperature(ctx, temperature, effect, duration)
+	})
+}
+
+func (gb *groupBase) StartFlow(ctx context.Context, flow *Flow) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.StartFlow(ctx, flow)
+	})
+}
+
+func (gb *groupBase) ApplyScene(ctx context.Context, scene Scene) error {
+	return fanOut(gb.members, func(bb *bulbBase) error {
+		return bb.ApplyScene(ctx, scene)
+	})
+}
+
+// Group drives several bulbs as one light. Commands are sent to all bulbs concurrently and fail with a GroupError
+// listing the bulbs they failed on, after having been sent to every other bulb.
+type Group struct {
+	groupBase
+
+	bulbs []*Bulb
+}
+
+func NewGroup(bulbs ...*Bulb) *Group {
+	members := make([]*bulbBase, len(bulbs))
+	for i, bulb := range bulbs {
+		members[i] = &bulb.bulbBase
+	}
+
+	return &Group{
+		groupBase: groupBase{members: members},
+		bulbs:     slices.Clone(bulbs),
+	}
+}
+
+func (g *Group) Bulbs() []*Bulb {
+	return slices.Clone(g.bulbs)
+}
+
+func (g *Group) Connect(ctx context.Context) error {
+	return fanOut(g.bulbs, func(bulb *Bulb) error {
+		return bulb.Connect(ctx)
+	})
+}
+
+func (g *Group) Disconnect() error {
+	return fanOut(g.bulbs, func(bulb *Bulb) error {
+		return bulb.Disconnect()
+	})
+}
+
+// EnableMusicMode enters music mode on every bulb, all connecting to the server, and runs the callback with the
+// bulbs that made it. Bulbs that failed to enter music mode are left out and logged, so the callback only fails to
+// run if none made it. Bulbs whose control connection is lost, which ends their music mode, re-enter it once they
+// are connected again.
+func (g *Group) EnableMusicMode(ctx context.Context, server *MusicServer, callback func(context.Context, *MusicModeGroup) error) error {
+	if len(g.bulbs) == 0 {
+		return errors.New("group has no bulbs")
+	}
+
+	groupContext, cancel := context.WithCancel(ctx)
+
+	// Entered concurrently, the server tells the connections apart by the IP of the bulb
+	musicBulbs := make([]*MusicModeBulb, len(g.bulbs))
+	musicContexts := make([]context.Context, len(g.bulbs))
+	err := fanOutIndexed(g.bulbs, func(i int, bulb *Bulb) error {
+		musicContext, musicBulb, err := bulb.startMusicMode(groupContext, server)
+		if err != nil {
+			return err
+		}
+
+		musicBulbs[i] = musicBulb
+		musicContexts[i] = musicContext
+
+		return nil
+	})
+
+	var keepers sync.WaitGroup
+	entered := make([]*MusicModeBulb, 0, len(musicBulbs))
+	for i, musicBulb := range musicBulbs {
+		if musicBulb == nil {
+			continue
+		}
+
+		entered = append(entered, musicBulb)
+
+		keepers.Add(1)
+		go func(bulb *Bulb, musicContext context.Context, musicBulb *MusicModeBulb) {
+			defer keepers.Done()
+
+			bulb.keepMusicMode(groupContext, musicContext, musicBulb, server)
+		}(g.bulbs[i], musicContexts[i], musicBulb)
+	}
+
+	// The keepers are stopped first, so that they don't re-enter music mode as it ends
+	defer func() {
+		cancel()
+		keepers.Wait()
+
+		for i, musicBulb := range musicBulbs {
+			if musicBulb != nil {
+				g.bulbs[i].endMusicMode(musicBulb)
+			}
+		}
+	}()
+
+	if err != nil {
+		if len(entered) == 0 {
+			return errors.Wrapf(err, "enable music mode")
+		}
+
+		slog.Warn("continuing without the bulbs that failed to enter music mode", slog.Any("error", err))
+	}
+
+	err = callback(groupContext, newMusicModeGroup(entered))
+
+	return errors.Wrapf(err, "music mode callback")
+}
+
+// keepMusicMode re-enters music mode whenever it ended while the context isn't done, once the control connection is
+// back. The bulb in music mode stays the same, so that the group it is in keeps reaching the bulb.
+func (bb *Bulb) keepMusicMode(ctx context.Context, musicContext context.Context, bulb *MusicModeBulb, server *MusicServer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-musicContext.Done():
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.Warn("group bulb left music mode, re-entering it once it is connected", slog.String("addr", bb.Addr().String()))
+
+		for backoff := minReconnectBackoff; ; backoff = min(backoff*2, maxReconnectBackoff) {
+			if !bb.waitConnected(ctx) {
+				if ctx.Err() == nil {
+					slog.Error("group bulb was disconnected, it no longer follows the group", slog.String("addr", bb.Addr().String()))
+				}
+
+				return
+			}
+
+			var err error
+			if musicContext, err = bb.runMusicMode(ctx, server, bulb); err == nil {
+				bulb.reentries.Add(1)
+				slog.Info("group bulb re-entered music mode", slog.String("addr", bb.Addr().String()))
+
+				break
+			}
+
+			slog.Warn("re-enter music mode of group bulb", slog.String("addr", bb.Addr().String()), slog.Any("error", err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// MusicModeGroup is a group of bulbs in music mode
+type MusicModeGroup struct {
+	groupBase
+
+	bulbs []*MusicModeBulb
+}
+
+func newMusicModeGroup(bulbs []*MusicModeBulb) *MusicModeGroup {
+	members := make([]*bulbBase, len(bulbs))
+	for i, bulb := range bulbs {
+		members[i] = &bulb.bulbBase
+	}
+
+	return &MusicModeGroup{
+		groupBase: groupBase{members: members},
+		bulbs:     bulbs,
+	}
+}
+
+func (g *MusicModeGroup) Bulbs() []*MusicModeBulb {
+	return slices.Clone(g.bulbs)
+}
@@ -0,0 +1,121 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+func TestGroupMusicModeReentersAfterLostConnection(t *testing.T) {
+	first, firstFake := newTestBulb(t, yeelighttest.Options{ID: "0x0000000000000001"})
+	second, secondFake := newTestBulb(t, yeelighttest.Options{ID: "0x0000000000000002"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	err := NewGroup(first, second).EnableMusicMode(ctx, newTestMusicServer(t), func(ctx context.Context, group *MusicModeGroup) error {
+		if n := len(group.Bulbs()); n != 2 {
+			t.Fatalf("%d bulbs entered music mode, want 2", n)
+		}
+
+		secondFake.DropConnections()
+
+		// Commands keep reaching the bulb once it re-entered music mode
+		for brightness := uint8(1); ; brightness = brightness%100 + 1 {
+			if err := group.SetBrightness(ctx, brightness, Sudden, 0); err != nil {
+				t.Logf("set brightness: %s", err)
+			}
+
+			if secondFake.MusicMode() && secondFake.Prop("bright") == firstFake.Prop("bright") && group.Bulbs()[1].Stats().Reentries > 0 {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				t.Fatal("bulb didn't re-enter music mode")
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupMusicModeSameBulbTwice(t *testing.T) {
+	bulb, _ := newTestBulb(t, yeelighttest.Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := NewGroup(bulb, bulb).EnableMusicMode(ctx, newTestMusicServer(t), func(ctx context.Context, group *MusicModeGroup) error {
+		for i, musicBulb := range group.Bulbs() {
+			if musicBulb == nil {
+				t.Errorf("bulb %d is missing", i)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupMusicModeWithBulbWithoutMusicMode(t *testing.T) {
+	color, colorFake := newTestBulb(t, yeelighttest.Options{ID: "0x0000000000000001"})
+	mono, monoFake := newTestBulb(t, yeelighttest.Options{
+		ID:      "0x0000000000000002",
+		Model:   "mono",
+		Support: []string{"get_prop", "set_default", "set_power", "toggle", "set_bright", "start_cf", "stop_cf", "set_scene", "cron_add", "cron_get", "cron_del", "set_adjust", "set_name"},
+	})
+	connected := len(monoFake.Commands())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := NewGroup(color, mono).EnableMusicMode(ctx, newTestMusicServer(t), func(ctx context.Context, group *MusicModeGroup) error {
+		if n := len(group.Bulbs()); n != 2 {
+			t.Fatalf("%d bulbs run the callback, want 2", n)
+		}
+
+		if !colorFake.MusicMode() {
+			t.Error("color bulb didn't enter music mode")
+		}
+
+		// Mono bulbs can only take part in a show with their brightness
+		if err := group.Bulbs()[1].SetHSV(ctx, 120, 100, 30, Smooth, 100); !errors.Is(err, ErrColorInvalid) {
+			t.Errorf("got error %v setting the color of a mono bulb, want %v", err, ErrColorInvalid)
+		}
+
+		if err := group.SetBrightness(ctx, 30, Smooth, 100); err != nil {
+			return err
+		}
+
+		for colorFake.Prop("bright") != "30" || monoFake.Prop("bright") != "30" {
+			select {
+			case <-ctx.Done():
+				t.Fatal("brightness not set on both bulbs")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+
+		if stats := group.Bulbs()[1].Stats(); stats.FallbackCommands != 1 {
+			t.Errorf("sent %d commands over the control connection, want 1", stats.FallbackCommands)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range monoFake.Commands()[connected:] {
+		if cmd.Method != "set_bright" || cmd.Music {
+			t.Errorf("bulb without music mode received %s (music: %t), want set_bright over the control connection only", cmd.Method, cmd.Music)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package yeelight
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// musicModeIP returns the address the bulb is asked to connect to in music mode: the one advertised by the server
+// if set, or else the local address of the route to the bulb, which is on the interface the bulb can reach us on
+func (bb *Bulb) musicModeIP(server *MusicServer) (netip.Addr, error) {
+	if bb.ConnectionState() != Connected {
+		return netip.Addr{}, errors.New("bulb is not connected")
+	}
+
+	bulbIP := bb.Addr().Addr()
+
+	if server.advertiseIP.IsValid() {
+		slog.Info("music mode address", slog.String("bulb", bulbIP.String()), slog.String("ip", server.advertiseIP.String()), slog.String("reason", "configured"))
+
+		return server.advertiseIP, nil
+	}
+
+	ip, err := routeLocalIP(bulbIP)
+	reason := "route to bulb"
+	if err != nil {
+		slog.Warn("find route to bulb, falling back to the address of the control connection", slog.String("bulb", bulbIP.String()), slog.Any("error", err))
+
+		if ip, err = bb.controlLocalIP(); err != nil {
+			return netip.Addr{}, err
+		}
+		reason = "control connection"
+	}
+
+	slog.Info("music mode address", slog.String("bulb", bulbIP.String()), slog.String("ip", ip.String()), slog.String("interface", interfaceName(ip)), slog.String("reason", reason))
+
+	return ip, nil
+}
+
+// controlLocalIP returns the local address of the control connection
+func (bb *Bulb) controlLocalIP() (netip.Addr, error) {
+	conn := bb.getConn()
+	if conn == nil {
+		return netip.Addr{}, errors.New("bulb is not connected")
+	}
+
+	host, _, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "parse local address of control connection")
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "parse local address of control connection")
+	}
+
+	return ip.Unmap(), nil
+}
+
+// routeLocalIP returns the source address the system picks for packets to the IP. Connecting a UDP socket only
+// looks up the route, nothing is sent.
+func routeLocalIP(ip netip.Addr) (netip.Addr, error) {
+	conn, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, controlPort)))
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "look up route to %s", ip)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap(), nil
+}
+
+// interfaceName returns the name of the interface with the address, for diagnostics
+func interfaceName(ip netip.Addr) string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "unknown"
+	}
+
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			if ifaceIP, ok := netip.AddrFromSlice(ipNet.IP); ok && ifaceIP.Unmap() == ip {
+				return iface.Name
+			}
+		}
+	}
+
+	return "unknown"
+}
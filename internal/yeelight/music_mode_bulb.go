@@ -2,21 +2,200 @@ package yeelight
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// keep-alive period of the music mode connection, so that a vanished bulb is noticed on the next write
+	musicKeepAlivePeriod = 10 * time.Second
+	// interval at which the control connection is used to check whether the bulb is still in music mode
+	musicProbeInterval = 30 * time.Second
 )
 
+// MusicModeStats counts how often the music mode connection had to be recovered
+type MusicModeStats struct {
+	// Failures is the number of times the music mode connection was found dead
+	Failures uint64
+	// Reentries is the number of times music mode was successfully re-entered
+	Reentries uint64
+	// FallbackCommands is the number of commands sent over the control connection while music mode was down, or
+	// because the bulb has no music mode
+	FallbackCommands uint64
+	// Sent is the number of commands written to the bulb
+	Sent uint64
+	// Dropped is the number of commands that were never sent, because they failed or too many were waiting
+	Dropped uint64
+	// Coalesced is the number of commands replaced by a newer one for the same property before being sent
+	Coalesced uint64
+}
+
 type MusicModeBulb struct {
 	bulbBase
+
+	control *Bulb
+	broken  chan struct{}
+
+	// interval is the minimum time between two commands
+	interval  time.Duration
+	pendingMu sync.Mutex
+	pending   []command
+	wake      chan struct{}
+	// unsent is the number of queued commands, including the one being written
+	unsent atomic.Int64
+
+	failures         atomic.Uint64
+	reentries        atomic.Uint64
+	fallbackCommands atomic.Uint64
+	sent             atomic.Uint64
+	dropped          atomic.Uint64
+	coalesced        atomic.Uint64
 }
 
-func newMusicModeBulb(b *bulbInfo, conn net.Conn) *MusicModeBulb {
-	return &MusicModeBulb{
+func newMusicModeBulb(control *Bulb) *MusicModeBulb {
+	bulb := &MusicModeBulb{
 		bulbBase: bulbBase{
-			bulbInfo: b,
-			conn:     conn,
-			commandCallback: func(ctx context.Context, cmd command) ([]string, error) {
-				return nil, nil
-			},
+			bulbInfo: control.bulbInfo,
 		},
+		control:  control,
+		broken:   make(chan struct{}, 1),
+		interval: control.musicCommandInterval(),
+		wake:     make(chan struct{}, 1),
+	}
+	bulb.sendCommand = bulb.send
+
+	return bulb
+}
+
+func (mb *MusicModeBulb) Stats() MusicModeStats {
+	return MusicModeStats{
+		Failures:         mb.failures.Load(),
+		Reentries:        mb.reentries.Load(),
+		FallbackCommands: mb.fallbackCommands.Load(),
+		Sent:             mb.sent.Load(),
+		Dropped:          mb.dropped.Load(),
+		Coalesced:        mb.coalesced.Load(),
+	}
+}
+
+func (mb *MusicModeBulb) Disconnect() error {
+	conn := mb.getConn()
+	if conn == nil || !mb.compareAndSwapConn(conn, nil) {
+		return nil
+	}
+
+	return conn.Close()
+}
+
+// attach makes the connection the one commands are written to and watches it for being closed by the bulb
+func (mb *MusicModeBulb) attach(conn net.Conn) {
+	if tcpConn, ok := unwrapConn(conn).(*net.TCPConn); ok {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			slog.Warn("enable keep-alive on music mode connection", slog.Any("error", err))
+		}
+		if err := tcpConn.SetKeepAlivePeriod(musicKeepAlivePeriod); err != nil {
+			slog.Warn("set keep-alive period on music mode connection", slog.Any("error", err))
+		}
+	}
+
+	mb.setConn(conn)
+
+	// The bulb never sends anything in music mode, so the read only returns once the connection is gone
+	go func() {
+		_, err := io.Copy(io.Discard, conn)
+		if err == nil {
+			err = io.EOF
+		}
+
+		mb.fail(conn, err)
+	}()
+}
+
+// fail marks the connection as dead and notifies the supervisor, unless it was already replaced
+func (mb *MusicModeBulb) fail(conn net.Conn, err error) {
+	if !mb.compareAndSwapConn(conn, nil) {
+		return
+	}
+
+	conn.Close()
+	mb.failures.Add(1)
+
+	slog.Warn("music mode connection lost", slog.Any("error", err))
+
+	select {
+	case mb.broken <- struct{}{}:
+	default:
+	}
+}
+
+// send queues the command for the pacer without waiting, since the bulb doesn't reply in music mode
+func (mb *MusicModeBulb) send(ctx context.Context, cmd command) ([]string, error) {
+	mb.enqueue(cmd)
+
+	return nil, nil
+}
+
+// superviseMusicMode probes the music mode connection and re-enters music mode whenever it is lost
+func (bb *Bulb) superviseMusicMode(ctx context.Context, mb *MusicModeBulb, server *MusicServer) {
+	probe := time.NewTicker(musicProbeInterval)
+	defer probe.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-probe.C:
+			conn := mb.getConn()
+			if conn == nil {
+				continue
+			}
+
+			res, err := bb.executeCommand(ctx, "get_prop", "music_on")
+			if err != nil {
+				slog.Debug("probe music mode", slog.Any("error", err))
+				continue
+			}
+
+			if len(res) == 1 && res[0] == "0" {
+				mb.fail(conn, errors.New("bulb left music mode"))
+			}
+
+			continue
+		case <-mb.broken:
+		}
+
+		backoff := minReconnectBackoff
+		for {
+			// The bulb may still consider itself in music mode, in which case set_music 1 is refused
+			if _, err := bb.executeCommand(ctx, "set_music", 0); err != nil {
+				slog.Debug("leave music mode before re-entering it", slog.Any("error", err))
+			}
+
+			conn, err := bb.enterMusicMode(ctx, server)
+			if err == nil {
+				mb.attach(conn)
+				mb.reentries.Add(1)
+
+				slog.Info("re-entered music mode")
+
+				break
+			}
+
+			slog.Warn("re-enter music mode", slog.Any("error", err))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = min(backoff*2, maxReconnectBackoff)
+		}
 	}
 }
@@ -0,0 +1,206 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// commands per second sent in music mode to models missing from modelMusicCommandRates
+	defaultMusicCommandRate = 20
+	// commands without a coalescing key that may wait to be sent, beyond which the oldest are dropped
+	maxPendingMusicCommands = 16
+	// how long writing a command may take before the music mode connection is considered stalled
+	musicWriteTimeout = 500 * time.Millisecond
+)
+
+// modelMusicCommandRates holds the number of commands per second each model keeps up with in music mode. Music mode
+// has no quota, but bulbs sent more than this lag behind and skip transitions.
+var modelMusicCommandRates = map[string]int{
+	"mono":    10,
+	"color":   20,
+	"stripe":  30,
+	"ceiling": 10,
+	"bslamp":  20,
+	"ct_bulb": 10,
+	"lamp":    10,
+}
+
+func modelMusicCommandRate(model string) int {
+	if rate, ok := modelMusicCommandRates[model]; ok {
+		return rate
+	}
+
+	return defaultMusicCommandRate
+}
+
+// SetMusicCommandRate overrides the number of commands per second sent in music mode, from the next time it is entered
+func (bb *Bulb) SetMusicCommandRate(perSecond int) {
+	bb.musicMu.Lock()
+	defer bb.musicMu.Unlock()
+
+	bb.musicCommandRate = perSecond
+}
+
+func (bb *Bulb) musicCommandInterval() time.Duration {
+	bb.musicMu.Lock()
+	rate := bb.musicCommandRate
+	bb.musicMu.Unlock()
+
+	if rate <= 0 {
+		rate = modelMusicCommandRate(bb.Model())
+	}
+
+	return time.Second / time.Duration(rate)
+}
+
+// enqueue queues the command to be sent by the pacer. A queued command setting the same property is dropped, since
+// only the latest matters, and the new one queued after the commands queued in the meantime, which it may override.
+func (mb *MusicModeBulb) enqueue(cmd command) {
+	mb.pendingMu.Lock()
+	defer mb.pendingMu.Unlock()
+
+	key := coalescingKey(cmd)
+	replaced := false
+	if key != "" {
+		if i := slices.IndexFunc(mb.pending, func(pending command) bool {
+			return coalescingKey(pending) == key
+		}); i != -1 {
+			mb.pending = slices.Delete(mb.pending, i, i+1)
+			mb.coalesced.Add(1)
+			replaced = true
+		}
+	}
+
+	mb.pending = append(mb.pending, cmd)
+	if !replaced {
+		mb.unsent.Add(1)
+
+		if len(mb.pending) > maxPendingMusicCommands {
+			mb.pending = mb.pending[1:]
+			mb.unsent.Add(-1)
+			mb.dropped.Add(1)
+		}
+	}
+
+	select {
+	case mb.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (mb *MusicModeBulb) dequeue() (command, bool) {
+	mb.pendingMu.Lock()
+	defer mb.pendingMu.Unlock()
+
+	if len(mb.pending) == 0 {
+		return command{}, false
+	}
+
+	cmd := mb.pending[0]
+	mb.pending = mb.pending[1:]
+
+	return cmd, true
+}
+
+// pace sends the queued commands no faster than the command rate of the bulb until the context is done, when the
+// ones still queued are dropped
+func (mb *MusicModeBulb) pace(ctx context.Context) {
+	var lastSent time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			mb.dropPending()
+			return
+		case <-mb.wake:
+		}
+
+		for {
+			// Waiting before taking the next command lets newer ones replace it in the meantime
+			if wait := mb.interval - time.Since(lastSent); wait > 0 {
+				select {
+				case <-ctx.Done():
+					mb.dropPending()
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			cmd, ok := mb.dequeue()
+			if !ok {
+				break
+			}
+
+			mb.write(ctx, cmd)
+			mb.unsent.Add(-1)
+			lastSent = time.Now()
+		}
+	}
+}
+
+func (mb *MusicModeBulb) dropPending() {
+	mb.pendingMu.Lock()
+	defer mb.pendingMu.Unlock()
+
+	mb.dropped.Add(uint64(len(mb.pending)))
+	mb.unsent.Add(-int64(len(mb.pending)))
+	mb.pending = nil
+}
+
+// flush waits until the queued commands are sent or the context is done
+func (mb *MusicModeBulb) flush(ctx context.Context) {
+	ticker := time.NewTicker(mb.interval)
+	defer ticker.Stop()
+
+	for mb.unsent.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// write sends the command over the music mode connection, or over the control connection while it is down or if
+// the bulb has no music mode.
+// A write that doesn't finish in time leaves the connection in an unknown state, so it is treated as lost.
+func (mb *MusicModeBulb) write(ctx context.Context, cmd command) {
+	if conn := mb.getConn(); conn != nil {
+		err := conn.SetWriteDeadline(time.Now().Add(musicWriteTimeout))
+		if err == nil {
+			err = mb.writeCommand(conn, cmd)
+		}
+
+		if err == nil {
+			mb.sent.Add(1)
+			return
+		}
+
+		mb.fail(conn, err)
+	}
+
+	mb.fallbackCommands.Add(1)
+
+	// Queued behind user initiated power and brightness changes, which the show must not hold up
+	if _, err := mb.control.executeCommand(WithPriority(ctx, PriorityNormal), cmd.Method, cmd.Params...); err != nil {
+		if errors.Is(err, errCoalesced) {
+			mb.coalesced.Add(1)
+			return
+		}
+
+		if !errors.Is(err, context.Canceled) {
+			slog.Debug("send music mode command over the control connection", slog.String("method", cmd.Method), slog.Any("error", err))
+		}
+
+		mb.dropped.Add(1)
+
+		return
+	}
+
+	mb.sent.Add(1)
+}
@@ -0,0 +1,55 @@
+package yeelight
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMusicPacerQueuesReplacementLast(t *testing.T) {
+	mb := &MusicModeBulb{wake: make(chan struct{}, 1)}
+
+	flow := newCommand(2, "start_cf", 1, 1, "100, 1, 16711680, 80")
+	mb.enqueue(newCommand(1, "set_bright", 10, "smooth", 100))
+	mb.enqueue(flow)
+	mb.enqueue(newCommand(3, "set_power", "on", "smooth", 100))
+	// Sent after the flow, which sets the brightness as well
+	mb.enqueue(newCommand(4, "set_bright", 50, "smooth", 100))
+
+	var sent []int
+	for {
+		cmd, ok := mb.dequeue()
+		if !ok {
+			break
+		}
+
+		sent = append(sent, cmd.ID)
+	}
+
+	if want := []int{2, 3, 4}; !slices.Equal(sent, want) {
+		t.Fatalf("sent %v, want %v", sent, want)
+	}
+
+	if coalesced := mb.coalesced.Load(); coalesced != 1 {
+		t.Errorf("coalesced %d commands, want 1", coalesced)
+	}
+
+	if unsent := mb.unsent.Load(); unsent != 3 {
+		t.Errorf("counted %d unsent commands, want 3", unsent)
+	}
+}
+
+func TestMusicPacerDropsOldestBeyondLimit(t *testing.T) {
+	mb := &MusicModeBulb{wake: make(chan struct{}, 1)}
+
+	for i := 1; i <= maxPendingMusicCommands+2; i++ {
+		mb.enqueue(newCommand(i, "cron_add", 0, 15))
+	}
+
+	if len(mb.pending) != maxPendingMusicCommands || mb.pending[0].ID != 3 {
+		t.Fatalf("queued %d commands starting with %d, want %d starting with 3", len(mb.pending), mb.pending[0].ID, maxPendingMusicCommands)
+	}
+
+	if dropped := mb.dropped.Load(); dropped != 2 {
+		t.Errorf("dropped %d commands, want 2", dropped)
+	}
+}
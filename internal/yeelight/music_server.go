@@ -0,0 +1,189 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// how long a bulb asked to enter music mode has to connect to the music server
+const musicConnectTimeout = timeout
+
+// MusicServer accepts the connections of bulbs entering music mode on a single port, for any number of bulbs and
+// for as long as it is open. Each connection is handed to the bulb with the same IP that is waiting for one.
+type MusicServer struct {
+	ln   *net.TCPListener
+	done chan struct{}
+	wg   sync.WaitGroup
+	// advertiseIP is the address bulbs are asked to connect to, if they can't reach us at the local one
+	advertiseIP netip.Addr
+
+	mu sync.Mutex
+	// waiting are the bulbs that were sent set_music and haven't connected yet, by IP
+	waiting map[netip.Addr]chan net.Conn
+	// addrLocks let only one bulb per IP wait at a time, so that a connection can't go to the wrong one
+	addrLocks map[netip.Addr]*sync.Mutex
+}
+
+// NewMusicServer listens for music mode connections on the port of all interfaces. Port 0 picks a free port.
+// Bulbs are asked to connect to the advertised IP, e.g. the host of a Docker container, or if it is the zero
+// address, to the local address of the route to them. Bulbs only speak IPv4, so the advertised IP must be one.
+func NewMusicServer(port uint16, advertiseIP netip.Addr) (*MusicServer, error) {
+	if advertiseIP.IsValid() && !advertiseIP.Unmap().Is4() {
+		return nil, errors.Errorf("music mode address %s isn't an IPv4 address", advertiseIP)
+	}
+
+	ln, err := net.ListenTCP("tcp4", &net.TCPAddr{Port: int(port)})
+	if err != nil {
+		return nil, errors.Wrapf(err, "start music mode listener")
+	}
+
+	s := &MusicServer{
+		ln:          ln,
+		done:        make(chan struct{}),
+		advertiseIP: advertiseIP.Unmap(),
+		waiting:     make(map[netip.Addr]chan net.Conn),
+		addrLocks:   make(map[netip.Addr]*sync.Mutex),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Port returns the port bulbs are asked to connect to
+func (s *MusicServer) Port() uint16 {
+	return uint16(s.ln.Addr().(*net.TCPAddr).Port)
+}
+
+// Close stops accepting connections. Connections already handed to bulbs stay open.
+func (s *MusicServer) Close() error {
+	close(s.done)
+	err := s.ln.Close()
+	s.wg.Wait()
+
+	return err
+}
+
+func (s *MusicServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			slog.Warn("accept music mode connection", slog.Any("error", err))
+
+			select {
+			case <-s.done:
+				return
+			case <-time.After(minReconnectBackoff):
+			}
+
+			continue
+		}
+
+		s.dispatch(conn)
+	}
+}
+
+// dispatch hands the connection to the bulb waiting for it, or closes it if no bulb with its IP is waiting, like a
+// bulb that was too late to connect
+func (s *MusicServer) dispatch(conn net.Conn) {
+	remote, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		slog.Warn("closing music mode connection of unknown address", slog.String("remote", conn.RemoteAddr().String()), slog.Any("error", err))
+		conn.Close()
+
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ip := remote.Addr().Unmap()
+	waiting, ok := s.waiting[ip]
+	if !ok {
+		slog.Warn("closing unexpected music mode connection", slog.String("remote", remote.String()))
+		conn.Close()
+
+		return
+	}
+
+	// Unregistered right away so that a second connection from the bulb is not taken for the first
+	delete(s.waiting, ip)
+	waiting <- conn
+}
+
+// connect registers the bulb as waiting for a connection, asks it to connect and waits until it does or the
+// timeout expires
+func (s *MusicServer) connect(ctx context.Context, bulbIP netip.Addr, ask func() error) (net.Conn, error) {
+	bulbIP = bulbIP.Unmap()
+
+	lock := s.addrLock(bulbIP)
+	lock.Lock()
+	defer lock.Unlock()
+
+	waiting := make(chan net.Conn, 1)
+
+	s.mu.Lock()
+	s.waiting[bulbIP] = waiting
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.waiting[bulbIP] == waiting {
+			delete(s.waiting, bulbIP)
+		}
+		s.mu.Unlock()
+
+		// The connection may have been dispatched right after giving up on it
+		select {
+		case conn := <-waiting:
+			conn.Close()
+		default:
+		}
+	}()
+
+	if err := ask(); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(musicConnectTimeout)
+	defer timer.Stop()
+
+	select {
+	case conn := <-waiting:
+		return conn, nil
+	case <-timer.C:
+		return nil, errors.Errorf("bulb %s didn't connect within %s", bulbIP, musicConnectTimeout)
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err())
+	case <-s.done:
+		return nil, errors.New("music server closed")
+	}
+}
+
+func (s *MusicServer) addrLock(ip netip.Addr) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lock, ok := s.addrLocks[ip]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.addrLocks[ip] = lock
+	}
+
+	return lock
+}
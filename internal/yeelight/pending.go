@@ -0,0 +1,97 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// pendingCommands routes command results read from the connection back to the callers waiting for them
+type pendingCommands struct {
+	mu      sync.Mutex
+	waiters map[int]chan commandResult
+}
+
+func newPendingCommands() *pendingCommands {
+	return &pendingCommands{
+		waiters: make(map[int]chan commandResult),
+	}
+}
+
+// add registers a command before it is written so that its result can't arrive before anyone is waiting for it
+func (p *pendingCommands) add(id int) <-chan commandResult {
+	// Buffered so that resolving never blocks the reader, even if the caller already gave up
+	ch := make(chan commandResult, 1)
+
+	p.mu.Lock()
+	p.waiters[id] = ch
+	p.mu.Unlock()
+
+	return ch
+}
+
+func (p *pendingCommands) remove(id int) {
+	p.mu.Lock()
+	delete(p.waiters, id)
+	p.mu.Unlock()
+}
+
+// resolve hands the result to the waiting caller and reports whether there was one
+func (p *pendingCommands) resolve(result commandResult) bool {
+	p.mu.Lock()
+	ch, ok := p.waiters[result.ID]
+	delete(p.waiters, result.ID)
+	p.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	ch <- result
+
+	return true
+}
+
+// failAll unblocks every waiting caller, e.g. because the connection was lost
+func (p *pendingCommands) failAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, ch := range p.waiters {
+		close(ch)
+		delete(p.waiters, id)
+	}
+}
+
+// wait blocks until the result of the command arrives, the command times out or the context is done
+func (p *pendingCommands) wait(ctx context.Context, cmd command, results <-chan commandResult) ([]string, error) {
+	defer p.remove(cmd.ID)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result, ok := <-results:
+		if !ok {
+			return nil, errors.Wrapf(ErrDisconnected, "execute command %s (%v)", cmd.Method, cmd.Params)
+		}
+
+		if result.Error != nil {
+			return nil, errors.Wrapf(result.Error, "%s (%v)", cmd.Method, cmd.Params)
+		}
+
+		if len(result.Result) == 1 && result.Result[0] == "ok" {
+			return nil, nil
+		}
+
+		return result.Result, nil
+	case <-timer.C:
+		slog.Debug("command timed out", slog.Int("id", cmd.ID), slog.String("method", cmd.Method))
+		return nil, errors.Errorf("command %s (%v) timed out", cmd.Method, cmd.Params)
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "execute command %s (%v)", cmd.Method, cmd.Params)
+	}
+}
@@ -0,0 +1,196 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+// waitPending blocks until n commands are waiting for their result
+func waitPending(t *testing.T, p *pendingCommands, n int) {
+	t.Helper()
+
+	pending := func() int {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		return len(p.waiters)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for pending() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d commands pending, want %d", pending(), n)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPendingCommandsResolve(t *testing.T) {
+	p := newPendingCommands()
+
+	results := p.add(1)
+	if !p.resolve(commandResult{ID: 1, Result: []string{"on"}}) {
+		t.Fatal("result of a pending command not resolved")
+	}
+
+	got, err := p.wait(context.Background(), command{ID: 1, Method: "get_prop"}, results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"on"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	if p.resolve(commandResult{ID: 1, Result: []string{"on"}}) {
+		t.Fatal("result of a command resolved twice")
+	}
+
+	if p.resolve(commandResult{ID: 2, Result: []string{"ok"}}) {
+		t.Fatal("result of an unknown command resolved")
+	}
+}
+
+func TestPendingCommandsFailAll(t *testing.T) {
+	p := newPendingCommands()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		results := p.add(i)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, errs[i] = p.wait(context.Background(), command{ID: i, Method: "set_power"}, results)
+		}(i)
+	}
+
+	p.failAll()
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, ErrDisconnected) {
+			t.Errorf("command %d: got error %v, want %v", i, err, ErrDisconnected)
+		}
+	}
+
+	if p.resolve(commandResult{ID: 0, Result: []string{"ok"}}) {
+		t.Fatal("result of a failed command resolved")
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	props := map[string]string{"bright": "42", "ct": "2700", "rgb": "255", "hue": "120", "sat": "80", "name": "desk"}
+	bulb, fake := newTestBulb(t, yeelighttest.Options{Props: props})
+	fake.SetLatency(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(props))
+	for prop, value := range props {
+		wg.Add(1)
+		go func(prop, value string) {
+			defer wg.Done()
+
+			res, err := bulb.executeCommand(ctx, "get_prop", prop)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if !reflect.DeepEqual(res, []string{value}) {
+				errs <- fmt.Errorf("get_prop %s: got %v, want [%s]", prop, res, value)
+			}
+		}(prop, value)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestLateReplyIsDropped(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for a command to time out")
+	}
+
+	bulb, fake := newTestBulb(t, yeelighttest.Options{Props: map[string]string{"bright": "42"}})
+	fake.SetLatency(timeout + 500*time.Millisecond)
+
+	ctx := context.Background()
+	if _, err := bulb.executeCommand(ctx, "get_prop", "power"); err == nil {
+		t.Fatal("command answered after the timeout succeeded")
+	}
+
+	// Answered after the late reply to the timed out command, which must not be taken for this one's
+	fake.SetLatency(0)
+	res, err := bulb.executeCommand(ctx, "get_prop", "bright")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"42"}; !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %v, want %v", res, want)
+	}
+}
+
+func TestInFlightCommandsFailWhenDisconnected(t *testing.T) {
+	tests := []struct {
+		name       string
+		disconnect func(*Bulb, *yeelighttest.Bulb)
+	}{
+		{
+			name:       "disconnect",
+			disconnect: func(bulb *Bulb, _ *yeelighttest.Bulb) { bulb.Disconnect() },
+		},
+		{
+			name:       "lost connection",
+			disconnect: func(_ *Bulb, fake *yeelighttest.Bulb) { fake.DropConnections() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bulb, fake := newTestBulb(t, yeelighttest.Options{})
+			fake.SetLatency(time.Second)
+
+			const n = 4
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				go func() {
+					_, err := bulb.executeCommand(context.Background(), "get_prop", "power")
+					errs <- err
+				}()
+			}
+
+			waitPending(t, bulb.pending, n)
+			tt.disconnect(bulb, fake)
+
+			for i := 0; i < n; i++ {
+				select {
+				case err := <-errs:
+					if !errors.Is(err, ErrDisconnected) {
+						t.Errorf("got error %v, want %v", err, ErrDisconnected)
+					}
+				case <-time.After(timeout / 2):
+					t.Fatal("command still waiting after the connection was closed")
+				}
+			}
+		})
+	}
+}
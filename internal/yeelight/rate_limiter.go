@@ -0,0 +1,203 @@
+package yeelight
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// commands per minute allowed on the control connection, the same for every model
+	defaultCommandQuota = 60
+	// share of the quota that may be used in a burst
+	commandBurstDivisor = 6
+)
+
+// errCoalesced is returned to callers whose queued command was superseded by a newer one for the same property, so
+// they can tell it apart from a command that was sent
+var errCoalesced = fmt.Errorf("command superseded by a newer one")
+
+type Priority uint8
+
+const (
+	// PriorityLow is used for background work like polling props
+	PriorityLow Priority = iota + 1
+	// PriorityNormal is used for most commands
+	PriorityNormal
+	// PriorityHigh is used for user initiated power and brightness changes
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+// WithPriority overrides the priority of commands executed with the returned context on the control connection
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func commandPriority(ctx context.Context, cmd command) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	_, method := methodLight(cmd.Method)
+
+	switch method {
+	case "get_prop":
+		return PriorityLow
+	case "set_power", "toggle", "set_bright":
+		return PriorityHigh
+	default:
+		return PriorityNormal
+	}
+}
+
+// coalescingKey returns the property a command sets, so that only the latest of several queued commands setting it is sent
+func coalescingKey(cmd command) string {
+	l, method := methodLight(cmd.Method)
+
+	switch method {
+	case "set_power":
+		return l.method("power")
+	case "set_bright":
+		return l.method("bright")
+	case "set_rgb", "set_hsv", "set_ct_abx", "start_cf":
+		return l.method("color")
+	default:
+		// set_scene is never replaced, since it also sets the power and brightness, or schedules turning off
+		return ""
+	}
+}
+
+type limiterWaiter struct {
+	priority Priority
+	key      string
+	seq      uint64
+	ready    chan error
+}
+
+// rateLimiter is a token bucket that hands tokens to waiting commands in order of priority
+type rateLimiter struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+	seq      uint64
+	queue    []*limiterWaiter
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	l := &rateLimiter{}
+	l.setQuota(perMinute)
+
+	return l
+}
+
+// setQuota configures the bucket so that a full burst followed by the steady rate never exceeds the quota in any minute
+func (l *rateLimiter) setQuota(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := max(perMinute/commandBurstDivisor, 1)
+
+	l.capacity = float64(burst)
+	l.rate = float64(max(perMinute-burst, 1)) / time.Minute.Seconds()
+	l.tokens = l.capacity
+	l.last = time.Now()
+}
+
+// wait blocks until the command may be sent. It returns errCoalesced if a newer command for the same property
+// took its place and ErrRateLimited if the context is done first.
+func (l *rateLimiter) wait(ctx context.Context, priority Priority, key string) error {
+	w := l.enqueue(priority, key)
+
+	for {
+		delay := l.dispatch()
+
+		select {
+		case err := <-w.ready:
+			return err
+		case <-ctx.Done():
+			if !l.dequeue(w) {
+				// Granted or superseded in the meantime
+				return <-w.ready
+			}
+
+			return errors.Wrapf(ErrRateLimited, "%s", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (l *rateLimiter) enqueue(priority Priority, key string) *limiterWaiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	w := &limiterWaiter{
+		priority: priority,
+		key:      key,
+		seq:      l.seq,
+		ready:    make(chan error, 1),
+	}
+
+	if key != "" {
+		l.queue = slices.DeleteFunc(l.queue, func(queued *limiterWaiter) bool {
+			if queued.key != key {
+				return false
+			}
+
+			queued.ready <- errCoalesced
+
+			return true
+		})
+	}
+
+	l.queue = append(l.queue, w)
+
+	return w
+}
+
+func (l *rateLimiter) dequeue(w *limiterWaiter) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	idx := slices.Index(l.queue, w)
+	if idx == -1 {
+		return false
+	}
+
+	l.queue = slices.Delete(l.queue, idx, idx+1)
+
+	return true
+}
+
+// dispatch hands out the available tokens and returns the time until the next one becomes available
+func (l *rateLimiter) dispatch() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.capacity, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	for l.tokens >= 1 && len(l.queue) > 0 {
+		next := 0
+		for i, w := range l.queue {
+			if w.priority > l.queue[next].priority || (w.priority == l.queue[next].priority && w.seq < l.queue[next].seq) {
+				next = i
+			}
+		}
+
+		l.queue[next].ready <- nil
+		l.queue = slices.Delete(l.queue, next, next+1)
+		l.tokens--
+	}
+
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
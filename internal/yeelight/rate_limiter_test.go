@@ -0,0 +1,185 @@
+package yeelight
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// newDrainedRateLimiter returns a limiter without tokens that only gets new ones when they are handed out by grant
+func newDrainedRateLimiter() *rateLimiter {
+	return &rateLimiter{capacity: 1, rate: 1e-9, last: time.Now()}
+}
+
+// grant hands out a single token and returns the waiter that got it
+func grant(t *testing.T, l *rateLimiter, waiters ...*limiterWaiter) *limiterWaiter {
+	t.Helper()
+
+	l.mu.Lock()
+	l.tokens = 1
+	l.mu.Unlock()
+
+	l.dispatch()
+
+	var granted *limiterWaiter
+	for _, w := range waiters {
+		select {
+		case err := <-w.ready:
+			if err != nil {
+				t.Fatalf("waiter %d got error %v", w.seq, err)
+			}
+
+			if granted != nil {
+				t.Fatalf("waiters %d and %d both got the token", granted.seq, w.seq)
+			}
+
+			granted = w
+		default:
+		}
+	}
+
+	if granted == nil {
+		t.Fatal("no waiter got the token")
+	}
+
+	return granted
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	l := newDrainedRateLimiter()
+
+	low := l.enqueue(PriorityLow, "")
+	normal := l.enqueue(PriorityNormal, "")
+	firstHigh := l.enqueue(PriorityHigh, "")
+	secondHigh := l.enqueue(PriorityHigh, "")
+
+	waiters := []*limiterWaiter{low, normal, firstHigh, secondHigh}
+	for i, want := range []*limiterWaiter{firstHigh, secondHigh, normal, low} {
+		if got := grant(t, l, waiters...); got != want {
+			t.Fatalf("token %d went to waiter %d, want %d", i, got.seq, want.seq)
+		}
+	}
+}
+
+func TestRateLimiterCoalescing(t *testing.T) {
+	l := newDrainedRateLimiter()
+
+	first := l.enqueue(PriorityNormal, "bright")
+	other := l.enqueue(PriorityNormal, "color")
+	unkeyed := l.enqueue(PriorityNormal, "")
+	alsoUnkeyed := l.enqueue(PriorityNormal, "")
+	second := l.enqueue(PriorityNormal, "bright")
+
+	select {
+	case err := <-first.ready:
+		if !errors.Is(err, errCoalesced) {
+			t.Fatalf("got error %v for the superseded command, want %v", err, errCoalesced)
+		}
+	default:
+		t.Fatal("superseded command still waiting")
+	}
+
+	waiters := []*limiterWaiter{other, unkeyed, alsoUnkeyed, second}
+	for i, want := range waiters {
+		if got := grant(t, l, waiters...); got != want {
+			t.Fatalf("token %d went to waiter %d, want %d", i, got.seq, want.seq)
+		}
+	}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	// A burst of 100 commands, then one every 120ms
+	l := newRateLimiter(600)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := l.wait(ctx, PriorityNormal, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("burst took %s", elapsed)
+	}
+
+	start = time.Now()
+	if err := l.wait(ctx, PriorityNormal, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("command after the burst waited %s, want about 120ms", elapsed)
+	}
+}
+
+func TestRateLimiterContextDone(t *testing.T) {
+	l := newDrainedRateLimiter()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := l.wait(ctx, PriorityHigh, "power"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("got error %v, want %v", err, ErrRateLimited)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queue) != 0 {
+		t.Fatalf("%d commands still queued after giving up", len(l.queue))
+	}
+}
+
+func TestCoalescingKey(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{method: "set_power", want: "power"},
+		{method: "bg_set_power", want: "bg_power"},
+		{method: "set_bright", want: "bright"},
+		{method: "set_rgb", want: "color"},
+		{method: "set_hsv", want: "color"},
+		{method: "set_ct_abx", want: "color"},
+		{method: "start_cf", want: "color"},
+		{method: "bg_start_cf", want: "bg_color"},
+		{method: "set_scene", want: ""},
+		{method: "bg_set_scene", want: ""},
+		{method: "get_prop", want: ""},
+		{method: "cron_add", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := coalescingKey(newCommand(1, tt.method)); got != tt.want {
+			t.Errorf("coalescingKey(%s) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestSceneIsNotReplacedByColor(t *testing.T) {
+	l := newDrainedRateLimiter()
+
+	scene := newCommand(1, "set_scene", "color", 0xFF0000, 100)
+	color := newCommand(2, "set_rgb", 0x00FF00, "smooth", 100)
+
+	sceneWaiter := l.enqueue(PriorityNormal, coalescingKey(scene))
+	colorWaiter := l.enqueue(PriorityNormal, coalescingKey(color))
+
+	waiters := []*limiterWaiter{sceneWaiter, colorWaiter}
+	for i, want := range waiters {
+		if got := grant(t, l, waiters...); got != want {
+			t.Fatalf("token %d went to waiter %d, want %d", i, got.seq, want.seq)
+		}
+	}
+
+	// Nor in music mode, where the scene is only queued
+	mb := &MusicModeBulb{wake: make(chan struct{}, 1)}
+	mb.enqueue(scene)
+	mb.enqueue(color)
+
+	if len(mb.pending) != 2 || mb.coalesced.Load() != 0 {
+		t.Fatalf("queued %v in music mode, want the scene and the color", mb.pending)
+	}
+}
@@ -0,0 +1,241 @@
+package yeelight
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// max-age assumed for advertisements without a Cache-Control header
+	defaultMaxAge = time.Hour
+	// interval at which expired bulbs are removed from the registry
+	expiryInterval = 5 * time.Second
+)
+
+type RegistryEventType uint8
+
+const (
+	BulbAdded RegistryEventType = iota + 1
+	BulbUpdated
+	BulbRemoved
+)
+
+func (t RegistryEventType) String() string {
+	switch t {
+	case BulbAdded:
+		return "added"
+	case BulbUpdated:
+		return "updated"
+	case BulbRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+type RegistryEvent struct {
+	Type RegistryEventType
+	Bulb *Bulb
+}
+
+type registryEntry struct {
+	info    *bulbInfo
+	expires time.Time
+}
+
+// Registry keeps track of bulbs advertising themselves on the network via SSDP NOTIFY messages
+type Registry struct {
+	address string
+	events  chan RegistryEvent
+
+	mu      sync.Mutex
+	entries map[string]registryEntry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		address: ssdpAddress,
+		events:  make(chan RegistryEvent, 16),
+		entries: make(map[string]registryEntry),
+	}
+}
+
+// Events returns the channel on which bulbs joining, changing and leaving are published.
+// It must be drained while the registry is running.
+func (r *Registry) Events() <-chan RegistryEvent {
+	return r.events
+}
+
+// Bulbs returns the currently known bulbs sorted by ID
+func (r *Registry) Bulbs() []*Bulb {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bulbs := make([]*Bulb, 0, len(r.entries))
+	for _, entry := range r.entries {
+		bulbs = append(bulbs, newBulb(entry.info.clone()))
+	}
+
+	slices.SortFunc(bulbs, func(a, b *Bulb) int {
+		return cmp.Compare(a.ID(), b.ID())
+	})
+
+	return bulbs
+}
+
+// Lookup returns the bulb with the given ID if it is currently known
+func (r *Registry) Lookup(id string) (*Bulb, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	return newBulb(entry.info.clone()), true
+}
+
+// Run joins the SSDP multicast group and processes advertisements until the context is done
+func (r *Registry) Run(ctx context.Context) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", r.address)
+	if err != nil {
+		return errors.Wrapf(err, "resolve SSDP address")
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, udpAddr)
+	if err != nil {
+		return errors.Wrapf(err, "join SSDP multicast group")
+	}
+	defer conn.Close()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go r.expire(ctx)
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return errors.Wrapf(err, "read from SSDP multicast group")
+		}
+
+		msg, err := parseSSDPMessage(buf[:n])
+		if err != nil {
+			slog.Debug("ignoring invalid SSDP message", slog.Any("error", err))
+			continue
+		}
+
+		if !strings.HasPrefix(msg.startLine, "NOTIFY") {
+			continue
+		}
+
+		info, err := msg.bulbInfo()
+		if err != nil {
+			slog.Debug("ignoring invalid SSDP advertisement", slog.Any("error", err))
+			continue
+		}
+
+		if info.id == "" {
+			continue
+		}
+
+		if strings.EqualFold(msg.header("NTS"), "ssdp:byebye") {
+			r.remove(ctx, info.id)
+			continue
+		}
+
+		maxAge := msg.maxAge()
+		if maxAge == 0 {
+			maxAge = defaultMaxAge
+		}
+
+		r.update(ctx, info, time.Now().Add(maxAge))
+	}
+}
+
+func (r *Registry) update(ctx context.Context, info *bulbInfo, expires time.Time) {
+	r.mu.Lock()
+	previous, known := r.entries[info.id]
+	r.entries[info.id] = registryEntry{
+		info:    info,
+		expires: expires,
+	}
+	r.mu.Unlock()
+
+	switch {
+	case !known:
+		r.publish(ctx, BulbAdded, info)
+	case !sameAdvertisement(previous.info, info):
+		r.publish(ctx, BulbUpdated, info)
+	}
+}
+
+func (r *Registry) remove(ctx context.Context, id string) {
+	r.mu.Lock()
+	entry, ok := r.entries[id]
+	delete(r.entries, id)
+	r.mu.Unlock()
+
+	if ok {
+		r.publish(ctx, BulbRemoved, entry.info)
+	}
+}
+
+func (r *Registry) expire(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.mu.Lock()
+			var expired []*bulbInfo
+			for id, entry := range r.entries {
+				if now.After(entry.expires) {
+					expired = append(expired, entry.info)
+					delete(r.entries, id)
+				}
+			}
+			r.mu.Unlock()
+
+			for _, info := range expired {
+				r.publish(ctx, BulbRemoved, info)
+			}
+		}
+	}
+}
+
+func (r *Registry) publish(ctx context.Context, eventType RegistryEventType, info *bulbInfo) {
+	slog.Debug("bulb registry event", slog.String("type", eventType.String()), slog.String("id", info.id), slog.String("addr", info.addr.String()))
+
+	select {
+	case r.events <- RegistryEvent{Type: eventType, Bulb: newBulb(info.clone())}:
+	case <-ctx.Done():
+	}
+}
+
+func sameAdvertisement(a, b *bulbInfo) bool {
+	return a.Addr() == b.Addr() &&
+		a.model == b.model &&
+		a.firmwareVersion == b.firmwareVersion &&
+		slices.Equal(a.support, b.support) &&
+		a.State() == b.State()
+}
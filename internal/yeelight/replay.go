@@ -0,0 +1,129 @@
+package yeelight
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// Replay sends the commands of a trace to the bulb as they were recorded, e.g. to reproduce a bug against a
+// simulated bulb, and logs their results next to the recorded ones. The recorded timing is scaled by speed, or
+// ignored if speed isn't positive. Commands recorded in music mode are sent in music mode, entered on the server.
+// Commands keep their recorded IDs, so that recorded replies sent by the bulb answer the commands they answered.
+func (bb *Bulb) Replay(ctx context.Context, events []TraceEvent, server *MusicServer, speed float64) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	messages := TraceMessages(events)
+	recorded := recordedResults(messages)
+	commands := recordedCommands(messages)
+
+	for _, recordedCmd := range commands {
+		bb.reserveCommandIDs(recordedCmd.command.ID)
+	}
+
+	var music *MusicModeBulb
+	origin := events[0].Time
+	start := time.Now()
+
+	for _, recordedCmd := range commands {
+		event, cmd := recordedCmd.message, recordedCmd.command
+
+		// Music mode is entered on the server instead, since the recorded address isn't ours
+		if cmd.Method == "set_music" {
+			continue
+		}
+
+		if speed > 0 {
+			at := time.Duration(float64(event.Time.Sub(origin)) / speed)
+
+			select {
+			case <-ctx.Done():
+				return errors.Wrap(ctx.Err())
+			case <-time.After(time.Until(start.Add(at))):
+			}
+		}
+
+		if event.Conn == TraceMusic {
+			if music == nil {
+				var err error
+				if _, music, err = bb.startMusicMode(ctx, server); err != nil {
+					return errors.Wrapf(err, "enter music mode to replay its commands")
+				}
+				defer bb.endMusicMode(music)
+			}
+
+			// Sent as is, bypassing the checks of the state, which may be what went wrong
+			if _, err := music.sendCommand(ctx, cmd); err != nil {
+				slog.Warn("replayed music mode command failed", slog.String("method", cmd.Method), slog.Any("params", cmd.Params), slog.Any("error", err))
+			}
+
+			continue
+		}
+
+		result, err := bb.sendCommand(ctx, cmd)
+
+		attrs := []interface{}{slog.String("method", cmd.Method), slog.Any("params", cmd.Params), slog.Any("result", result), slog.Any("error", err)}
+		if res, ok := recorded[cmd.ID]; ok {
+			attrs = append(attrs, slog.Any("recordedResult", res.Result), slog.Any("recordedError", res.Error))
+		}
+
+		slog.Info("replayed command", attrs...)
+	}
+
+	if music != nil {
+		music.flush(ctx)
+	}
+
+	return nil
+}
+
+type recordedCommand struct {
+	message TraceMessage
+	command command
+}
+
+// recordedCommands returns the commands sent on either connection, in order
+func recordedCommands(messages []TraceMessage) []recordedCommand {
+	var commands []recordedCommand
+
+	for _, message := range messages {
+		if message.Direction != TraceSend {
+			continue
+		}
+
+		var cmd command
+		if err := json.Unmarshal(message.Line, &cmd); err != nil {
+			slog.Warn("skipping invalid command", slog.String("message", string(message.Line)), slog.Any("error", err))
+			continue
+		}
+
+		commands = append(commands, recordedCommand{message: message, command: cmd})
+	}
+
+	return commands
+}
+
+// recordedResults returns the results received on the control connection, by command ID
+func recordedResults(messages []TraceMessage) map[int]commandResult {
+	results := make(map[int]commandResult)
+
+	for _, message := range messages {
+		if message.Direction != TraceReceive || message.Conn != TraceControl {
+			continue
+		}
+
+		msg, err := decodeMessage(message.Line)
+		if err != nil || msg.kind != messageResult {
+			continue
+		}
+
+		results[msg.result.ID] = msg.result
+	}
+
+	return results
+}
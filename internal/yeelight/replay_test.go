@@ -0,0 +1,54 @@
+package yeelight
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+func TestReplayKeepsRecordedCommandIDs(t *testing.T) {
+	bulb, fake := newTestBulb(t, yeelighttest.Options{})
+	connected := len(fake.Commands())
+
+	origin := time.Now()
+	events := []TraceEvent{
+		{Time: origin, Conn: TraceControl, Direction: TraceSend, Data: "{\"id\":40,\"method\":\"set_bright\",\"params\":[40,\"sudden\",0]}\r\n"},
+		{Time: origin, Conn: TraceControl, Direction: TraceReceive, Data: "{\"id\":40,\"result\":[\"ok\"]}\r\n"},
+		{Time: origin, Conn: TraceControl, Direction: TraceSend, Data: "{\"id\":41,\"method\":\"get_prop\",\"params\":[\"bright\"]}\r\n"},
+		{Time: origin, Conn: TraceControl, Direction: TraceReceive, Data: "{\"id\":41,\"result\":[\"40\"]}\r\n"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := bulb.Replay(ctx, events, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sent after the replay, so it must not reuse a recorded ID
+	res, err := bulb.executeCommand(ctx, "get_prop", "power")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 1 || res[0] != "on" {
+		t.Fatalf("got %v, want [on]", res)
+	}
+
+	commands := fake.Commands()[connected:]
+	if len(commands) != 3 {
+		t.Fatalf("simulated bulb received %d commands, want 3", len(commands))
+	}
+
+	for i, want := range []int{40, 41} {
+		if commands[i].ID != want {
+			t.Errorf("replayed command %d has ID %d, want %d", i, commands[i].ID, want)
+		}
+	}
+
+	if id := commands[2].ID; id <= 41 {
+		t.Errorf("command sent after the replay has ID %d, which a replayed command used", id)
+	}
+}
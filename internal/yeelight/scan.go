@@ -0,0 +1,205 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/utils"
+)
+
+const (
+	// controlPort is the port bulbs listen on for control connections
+	controlPort = 55443
+	// ssdpPort is the port bulbs answer search requests on
+	ssdpPort = 1982
+	// number of addresses probed at the same time
+	scanConcurrency = 64
+	// how long to wait for a probed address to accept the connection, short because bulbs are on the local network
+	scanDialTimeout = 500 * time.Millisecond
+	// how long to wait for a scanned bulb to answer a search request
+	scanSearchTimeout = time.Second
+	// maxScanAddresses is the size of a /16, above which a scan would take too long to be useful
+	maxScanAddresses = 1 << 16
+)
+
+// Scan probes every address of the IPv4 prefix for the control port and confirms bulbs by requesting their props,
+// for networks where multicast doesn't reach us, like Docker bridge networks. The control protocol doesn't expose
+// the ID, model, firmware version and support list, so each bulb found is then sent a search request directly, which
+// unicast gets through. Bulbs that don't answer it only have an address and a state, like bulbs created with NewBulb.
+// The returned bulbs are sorted by address.
+func Scan(ctx context.Context, prefix netip.Prefix) ([]*Bulb, error) {
+	return scan(ctx, prefix, controlPort, ssdpPort)
+}
+
+// ScanPort works like Scan, but probes the given ports instead of the ones of the control and discovery protocols
+func ScanPort(ctx context.Context, prefix netip.Prefix, port, searchPort uint16) ([]*Bulb, error) {
+	return scan(ctx, prefix, port, searchPort)
+}
+
+func scan(ctx context.Context, prefix netip.Prefix, port, searchPort uint16) ([]*Bulb, error) {
+	addrs, err := prefixAddrs(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	addrCh := make(chan netip.Addr)
+	var mu sync.Mutex
+	var bulbs []*Bulb
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(scanConcurrency, len(addrs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for addr := range addrCh {
+				info, ok := probe(ctx, netip.AddrPortFrom(addr, port))
+				if !ok {
+					continue
+				}
+
+				identify(ctx, info, netip.AddrPortFrom(addr, searchPort))
+
+				mu.Lock()
+				bulbs = append(bulbs, newBulb(info))
+				mu.Unlock()
+			}
+		}()
+	}
+
+send:
+	for _, addr := range addrs {
+		select {
+		case addrCh <- addr:
+		case <-ctx.Done():
+			break send
+		}
+	}
+
+	close(addrCh)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, errors.Wrapf(err, "scan for bulbs")
+	}
+
+	sortBulbs(bulbs)
+
+	return bulbs, nil
+}
+
+// prefixAddrs returns the host addresses of the prefix, without the network and broadcast addresses
+func prefixAddrs(prefix netip.Prefix) ([]netip.Addr, error) {
+	if !prefix.Addr().Is4() {
+		return nil, errors.Errorf("only IPv4 prefixes can be scanned, got %s", prefix)
+	}
+
+	hostBits := 32 - prefix.Bits()
+	if 1<<hostBits > maxScanAddresses {
+		return nil, errors.Errorf("prefix %s is too large to scan, at most /16 is supported", prefix)
+	}
+
+	prefix = prefix.Masked()
+	addrs := make([]netip.Addr, 0, 1<<hostBits)
+	for addr := prefix.Addr(); prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr)
+	}
+
+	// /31 and /32 have no network and broadcast addresses
+	if hostBits > 1 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+
+	return addrs, nil
+}
+
+// probe connects to the address and requests the props of the main light. Anything that doesn't answer like a bulb
+// is ignored.
+func probe(ctx context.Context, addr netip.AddrPort) (*bulbInfo, bool) {
+	dialer := net.Dialer{Timeout: scanDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return nil, false
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, false
+	}
+
+	cmd := newCommand(1, "get_prop", utils.Map(mainLightProps, func(prop string) interface{} {
+		return prop
+	})...)
+
+	line, err := cmd.String()
+	if err != nil {
+		return nil, false
+	}
+
+	if _, err := conn.Write([]byte(line)); err != nil {
+		return nil, false
+	}
+
+	decoder := newMessageDecoder(conn)
+	for {
+		msg, err := decoder.Decode()
+		if err != nil {
+			return nil, false
+		}
+
+		if msg.kind != messageResult || msg.result.ID != cmd.ID {
+			continue
+		}
+
+		if msg.result.Error != nil || len(msg.result.Result) != len(mainLightProps) {
+			return nil, false
+		}
+
+		info := &bulbInfo{addr: addr}
+		for i, value := range msg.result.Result {
+			if value == "" {
+				continue
+			}
+
+			if err := info.state.setProp(mainLightProps[i], value); err != nil {
+				return nil, false
+			}
+		}
+
+		return info, true
+	}
+}
+
+// identify sends a search request to the address of a probed bulb and fills in the ID, model, firmware version and
+// support list it answers with. The bulb is left as it is if it doesn't answer.
+func identify(ctx context.Context, info *bulbInfo, addr netip.AddrPort) {
+	ctx, cancel := context.WithTimeout(ctx, scanSearchTimeout)
+	defer cancel()
+
+	var found *bulbInfo
+	err := search(ctx, addr.String(), func(answer *bulbInfo) bool {
+		if answer.addr.Addr() != info.addr.Addr() {
+			return false
+		}
+
+		found = answer
+
+		return true
+	})
+	if err != nil || found == nil {
+		slog.Debug("scanned bulb didn't answer the search request",
+			slog.String("addr", info.addr.String()), slog.Any("error", err))
+
+		return
+	}
+
+	info.id = found.id
+	info.model = found.model
+	info.firmwareVersion = found.firmwareVersion
+	info.support = found.support
+}
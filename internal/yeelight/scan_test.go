@@ -0,0 +1,84 @@
+package yeelight
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+func TestScanFillsInAdvertisedInfo(t *testing.T) {
+	support := []string{"get_prop", "set_power", "set_bright"}
+	fake, err := yeelighttest.NewBulb(yeelighttest.Options{
+		ID:              "0x0000000000000042",
+		Model:           "mono",
+		FirmwareVersion: "45",
+		Support:         support,
+		Props:           map[string]string{"bright": "30"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+
+	searchAddr := netip.MustParseAddrPort(fake.SSDPAddr())
+	bulbs, err := ScanPort(ctx, netip.MustParsePrefix("127.0.0.1/32"), fake.Addr().Port(), searchAddr.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bulbs) != 1 {
+		t.Fatalf("scanned %d bulbs, want 1", len(bulbs))
+	}
+
+	bulb := bulbs[0]
+	if bulb.Addr() != fake.Addr() {
+		t.Errorf("got address %s, want %s", bulb.Addr(), fake.Addr())
+	}
+
+	if bulb.ID() != fake.ID() || bulb.Model() != "mono" || bulb.FirmwareVersion() != "45" {
+		t.Errorf("got %s, model %s, firmware %s, want %s, model mono, firmware 45",
+			bulb.ID(), bulb.Model(), bulb.FirmwareVersion(), fake.ID())
+	}
+
+	if !reflect.DeepEqual(bulb.Support(), support) {
+		t.Errorf("got support %v, want %v", bulb.Support(), support)
+	}
+
+	if bulb.Capabilities().Color {
+		t.Error("scanned mono bulb has color")
+	}
+
+	if got := bulb.Brightness(); got != 30 {
+		t.Errorf("got brightness %d, want 30", got)
+	}
+}
+
+func TestScanKeepsBulbsNotAnsweringSearch(t *testing.T) {
+	fake, err := yeelighttest.NewBulb(yeelighttest.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	// Nothing answers search requests on the control port
+	start := time.Now()
+	bulbs, err := ScanPort(context.Background(), netip.MustParsePrefix("127.0.0.1/32"), fake.Addr().Port(), fake.Addr().Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bulbs) != 1 || bulbs[0].Addr() != fake.Addr() || bulbs[0].ID() != "" {
+		t.Fatalf("got %v, want an unidentified bulb at %s", discovered(bulbs), fake.Addr())
+	}
+
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("scan took %s, beyond the search timeout", elapsed)
+	}
+}
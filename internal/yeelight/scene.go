@@ -0,0 +1,260 @@
+package yeelight
+
+import (
+	"context"
+	"time"
+
+	"github.com/crazy3lf/colorconv"
+	"github.com/cybre/yeelight-controller/internal/errors"
+	"github.com/cybre/yeelight-controller/internal/utils"
+)
+
+// Scene is a state set_scene applies atomically, turning the light on if it is off.
+// It is one of ColorScene, HSVScene, CTScene, FlowScene or AutoDelayOffScene.
+type Scene interface {
+	params(ctRange ColorTemperatureRange) ([]interface{}, error)
+	apply(state *LightState)
+}
+
+// ColorScene sets an RGB color and brightness
+type ColorScene struct {
+	R, G, B    uint8
+	Brightness uint8
+}
+
+func (s ColorScene) params(ColorTemperatureRange) ([]interface{}, error) {
+	if err := validateBrightness(s.Brightness); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{"color", utils.RGBToInt(s.R, s.G, s.B), s.Brightness}, nil
+}
+
+func (s ColorScene) apply(state *LightState) {
+	state.colorMode = ColorModeRGB
+	state.rgb = utils.RGBToInt(s.R, s.G, s.B)
+	state.brightness = s.Brightness
+}
+
+// HSVScene sets a hue (0-359), saturation (0-100) and brightness
+type HSVScene struct {
+	Hue        uint16
+	Saturation uint8
+	Brightness uint8
+}
+
+func (s HSVScene) params(ColorTemperatureRange) ([]interface{}, error) {
+	if s.Hue > 359 {
+		return nil, errors.Wrap(ErrHueInvalid)
+	}
+
+	if s.Saturation > 100 {
+		return nil, errors.Wrap(ErrSaturationInvalid)
+	}
+
+	if err := validateBrightness(s.Brightness); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{"hsv", s.Hue, s.Saturation, s.Brightness}, nil
+}
+
+func (s HSVScene) apply(state *LightState) {
+	state.colorMode = ColorModeHSV
+	state.hue = s.Hue
+	state.saturation = s.Saturation
+	state.brightness = s.Brightness
+
+	if r, g, b, err := colorconv.HSVToRGB(float64(s.Hue), float64(s.Saturation)/100.0, 1); err == nil {
+		state.rgb = utils.RGBToInt(r, g, b)
+	}
+}
+
+// CTScene sets a color temperature, in Kelvin, and brightness
+type CTScene struct {
+	Temperature uint16
+	Brightness  uint8
+}
+
+func (s CTScene) params(ctRange ColorTemperatureRange) ([]interface{}, error) {
+	if err := ctRange.validate(s.Temperature); err != nil {
+		return nil, err
+	}
+
+	if err := validateBrightness(s.Brightness); err != nil {
+		return nil, err
+	}
+
+	return []interface{}{"ct", s.Temperature, s.Brightness}, nil
+}
+
+func (s CTScene) apply(state *LightState) {
+	state.colorMode = ColorModeTemperature
+	state.colorTemperature = s.Temperature
+	state.brightness = s.Brightness
+}
+
+// FlowScene starts a color flow
+type FlowScene struct {
+	Flow *Flow
+}
+
+func (s FlowScene) params(ctRange ColorTemperatureRange) ([]interface{}, error) {
+	if s.Flow == nil {
+		return nil, errors.Wrap(ErrFlowEmpty)
+	}
+
+	params, err := s.Flow.params(ctRange)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]interface{}{"cf"}, params...), nil
+}
+
+func (s FlowScene) apply(state *LightState) {
+	s.Flow.apply(state)
+}
+
+// AutoDelayOffScene sets the brightness and turns the light off once the delay, in whole minutes, has passed
+type AutoDelayOffScene struct {
+	Brightness uint8
+	Delay      time.Duration
+}
+
+func (s AutoDelayOffScene) params(ColorTemperatureRange) ([]interface{}, error) {
+	if err := validateBrightness(s.Brightness); err != nil {
+		return nil, err
+	}
+
+	minutes := int(s.Delay.Minutes())
+	if minutes < 1 {
+		return nil, errors.Wrap(ErrDelayInvalid)
+	}
+
+	return []interface{}{"auto_delay_off", s.Brightness, minutes}, nil
+}
+
+func (s AutoDelayOffScene) apply(state *LightState) {
+	state.brightness = s.Brightness
+}
+
+func validateBrightness(brightness uint8) error {
+	if brightness < 1 || brightness > 100 {
+		return errors.Wrap(ErrBrightnessInvalid)
+	}
+
+	return nil
+}
+
+// CurrentScene returns the scene that restores the color and brightness the light has now, on a bulb with color
+func (ls LightState) CurrentScene() Scene {
+	brightness := max(ls.brightness, 1)
+
+	switch ls.colorMode {
+	case ColorModeTemperature:
+		return CTScene{Temperature: ls.colorTemperature, Brightness: brightness}
+	case ColorModeHSV:
+		return HSVScene{Hue: ls.hue, Saturation: ls.saturation, Brightness: brightness}
+	default:
+		r, g, b := ls.RGB()
+
+		return ColorScene{R: r, G: g, B: b, Brightness: brightness}
+	}
+}
+
+// flowStep returns the step fading to the color and brightness the light has now
+func (ls LightState) flowStep(duration time.Duration) FlowStep {
+	duration = max(duration, minFlowStepDuration)
+	brightness := max(ls.brightness, 1)
+
+	switch ls.colorMode {
+	case ColorModeTemperature:
+		return CTStep{Duration: duration, Temperature: ls.colorTemperature, Brightness: brightness}
+	case ColorModeHSV:
+		r, g, b, err := colorconv.HSVToRGB(float64(ls.hue), float64(ls.saturation)/100.0, 1)
+		if err == nil {
+			return RGBStep{Duration: duration, R: r, G: g, B: b, Brightness: brightness}
+		}
+
+		fallthrough
+	default:
+		r, g, b := ls.RGB()
+
+		return RGBStep{Duration: duration, R: r, G: g, B: b, Brightness: brightness}
+	}
+}
+
+// Restore brings the bulb back to a snapshot taken with State, fading to its color and brightness over the duration
+func (bb *bulbBase) Restore(ctx context.Context, snapshot BulbState, duration time.Duration) error {
+	if snapshot.Power() != PowerOn {
+		return bb.TurnOff(ctx, Smooth, int(duration.Milliseconds()))
+	}
+
+	caps := bb.Capabilities()
+	if !caps.Color && !caps.ColorTemperature {
+		if bb.Power() != PowerOn {
+			if err := bb.TurnOn(ctx, Smooth, int(duration.Milliseconds())); err != nil {
+				return err
+			}
+		}
+
+		return bb.SetBrightness(ctx, max(snapshot.Brightness(), 1), Smooth, int(duration.Milliseconds()))
+	}
+
+	// set_scene turns the bulb on in one command, but can't fade
+	if bb.Power() != PowerOn {
+		return bb.ApplyScene(ctx, bb.sceneOf(snapshot.LightState))
+	}
+
+	if !caps.Color {
+		return bb.StartFlow(ctx, NewFlow(bb.whiteStep(snapshot.LightState, duration)))
+	}
+
+	return bb.StartFlow(ctx, NewFlow(snapshot.flowStep(duration)))
+}
+
+// sceneOf returns the scene that restores the color and brightness of the light state on the bulb. Bulbs without
+// color only accept white light, whatever color mode they report.
+func (bi *bulbInfo) sceneOf(ls LightState) Scene {
+	if bi.capabilities.Color {
+		return ls.CurrentScene()
+	}
+
+	return CTScene{Temperature: bi.whiteTemperature(ls), Brightness: max(ls.brightness, 1)}
+}
+
+// whiteStep is the flow step fading a bulb without color to the color temperature and brightness of the light state
+func (bi *bulbInfo) whiteStep(ls LightState, duration time.Duration) FlowStep {
+	return CTStep{Duration: max(duration, minFlowStepDuration), Temperature: bi.whiteTemperature(ls), Brightness: max(ls.brightness, 1)}
+}
+
+// whiteTemperature returns the color temperature of the light state within the range of the bulb
+func (bi *bulbInfo) whiteTemperature(ls LightState) uint16 {
+	ctRange := bi.ColorTemperatureRange()
+
+	return min(max(ls.colorTemperature, ctRange.Min), ctRange.Max)
+}
+
+// ApplyScene atomically turns the bulb on and sets its color and brightness
+func (bb *bulbBase) ApplyScene(ctx context.Context, scene Scene) error {
+	return bb.applyScene(ctx, mainLight, scene)
+}
+
+// ApplyScene atomically turns the background light on and sets its color and brightness
+func (bl *BackgroundLight) ApplyScene(ctx context.Context, scene Scene) error {
+	return bl.bulb.applyScene(ctx, backgroundLight, scene)
+}
+
+func (bb *bulbBase) applyScene(ctx context.Context, l light, scene Scene) error {
+	params, err := scene.params(bb.ColorTemperatureRange())
+	if err != nil {
+		return errors.Wrapf(err, "invalid scene")
+	}
+
+	return bb.executeUpdate(ctx, func(s *BulbState) {
+		state := s.light(l)
+		state.power = PowerOn
+		scene.apply(state)
+	}, l.method("set_scene"), params...)
+}
@@ -0,0 +1,104 @@
+package yeelight
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+const (
+	// location header prefix for yeelight bulbs
+	locationPrefix = "yeelight://"
+)
+
+// ssdpMessage is a parsed SSDP search response or NOTIFY advertisement
+type ssdpMessage struct {
+	startLine string
+	headers   map[string]string
+}
+
+func parseSSDPMessage(data []byte) (ssdpMessage, error) {
+	lines := strings.Split(string(data), lineEnding)
+	if len(lines) == 0 || lines[0] == "" {
+		return ssdpMessage{}, errors.New("empty SSDP message")
+	}
+
+	msg := ssdpMessage{
+		startLine: strings.TrimSpace(lines[0]),
+		headers:   make(map[string]string),
+	}
+
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		msg.headers[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	return msg, nil
+}
+
+func (m ssdpMessage) header(key string) string {
+	return m.headers[strings.ToLower(key)]
+}
+
+// maxAge returns the max-age directive of the Cache-Control header, or zero if there is none
+func (m ssdpMessage) maxAge() time.Duration {
+	for _, directive := range strings.Split(m.header("Cache-Control"), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(key, "max-age") {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || seconds < 0 {
+			return 0
+		}
+
+		return time.Duration(seconds) * time.Second
+	}
+
+	return 0
+}
+
+func (m ssdpMessage) bulbInfo() (*bulbInfo, error) {
+	location := m.header("Location")
+	if !strings.HasPrefix(location, locationPrefix) {
+		return nil, errors.Errorf("invalid bulb location: %q", location)
+	}
+
+	addr, err := netip.ParseAddrPort(strings.TrimPrefix(location, locationPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse bulb address")
+	}
+
+	info := &bulbInfo{
+		addr:            addr,
+		id:              m.header("id"),
+		model:           m.header("model"),
+		firmwareVersion: m.header("fw_ver"),
+	}
+
+	if support := m.header("support"); support != "" {
+		info.support = strings.Fields(support)
+	}
+
+	for _, prop := range append(slices.Clone(mainLightProps), backgroundLightProps...) {
+		value, ok := m.headers[prop]
+		if !ok {
+			continue
+		}
+
+		if err := info.state.setProp(prop, value); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", prop)
+		}
+	}
+
+	return info, nil
+}
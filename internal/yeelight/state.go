@@ -0,0 +1,328 @@
+package yeelight
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const (
+	// how long the bulb may take to report a value we set, until it is considered to be set by someone else
+	expectedChangeWindow = 2 * timeout
+	// anyValue is expected when the outcome of a command isn't known, like for relative adjustments
+	anyValue = "*"
+)
+
+// relativeMethodProps are the props changed by methods whose outcome depends on the state of the bulb, without
+// the prefix of the background light
+var relativeMethodProps = map[string][]string{
+	"adjust_bright": {"bright"},
+	"adjust_ct":     {"ct", "color_mode"},
+	"adjust_color":  {"rgb", "hue", "sat", "color_mode"},
+	"set_adjust":    {"bright", "ct", "rgb", "hue", "sat", "color_mode"},
+}
+
+// BulbState is a snapshot of the state of a bulb
+type BulbState struct {
+	LightState
+
+	name       string
+	delayOff   time.Duration
+	background LightState
+}
+
+func (s BulbState) Name() string {
+	return s.name
+}
+
+// DelayOff returns the time left until the sleep timer turns the bulb off, or zero if no timer is set
+func (s BulbState) DelayOff() time.Duration {
+	return s.delayOff
+}
+
+// Background returns the state of the background light of dual-light models
+func (s BulbState) Background() LightState {
+	return s.background
+}
+
+func (s *BulbState) light(l light) *LightState {
+	if l == backgroundLight {
+		return &s.background
+	}
+
+	return &s.LightState
+}
+
+// changedProps returns the props that differ from the other state
+func (s BulbState) changedProps(other BulbState) []string {
+	props := append(s.LightState.changedProps(other.LightState, mainLight), s.background.changedProps(other.background, backgroundLight)...)
+
+	if s.name != other.name {
+		props = append(props, "name")
+	}
+
+	if s.delayOff != other.delayOff {
+		props = append(props, "delayoff")
+	}
+
+	return props
+}
+
+func (ls LightState) changedProps(other LightState, l light) []string {
+	var props []string
+
+	if ls.power != other.power {
+		props = append(props, l.prop("power"))
+	}
+
+	if ls.brightness != other.brightness {
+		props = append(props, l.prop("bright"))
+	}
+
+	if ls.colorMode != other.colorMode {
+		props = append(props, l.prop("color_mode"))
+	}
+
+	if ls.colorTemperature != other.colorTemperature {
+		props = append(props, l.prop("ct"))
+	}
+
+	if ls.rgb != other.rgb {
+		props = append(props, l.prop("rgb"))
+	}
+
+	if ls.hue != other.hue {
+		props = append(props, l.prop("hue"))
+	}
+
+	if ls.saturation != other.saturation {
+		props = append(props, l.prop("sat"))
+	}
+
+	return props
+}
+
+// StateSource is where a change of the bulb state came from
+type StateSource uint8
+
+const (
+	// StateSourceLocal is a change made through this package
+	StateSourceLocal StateSource = iota + 1
+	// StateSourceExternal is a change made by another controller, like the Yeelight app or a voice assistant
+	StateSourceExternal
+)
+
+func (s StateSource) String() string {
+	switch s {
+	case StateSourceLocal:
+		return "local"
+	case StateSourceExternal:
+		return "external"
+	default:
+		return "unknown"
+	}
+}
+
+// StateChange reports the props that changed and the state after the change
+type StateChange struct {
+	Props  []string
+	Source StateSource
+	State  BulbState
+}
+
+// coalesce merges a later local change into this one, since the later state holds the earlier one's changes
+func (c StateChange) coalesce(next StateChange) (StateChange, bool) {
+	if c.Source != StateSourceLocal || next.Source != StateSourceLocal {
+		return c, false
+	}
+
+	props := slices.Clone(c.Props)
+	for _, prop := range next.Props {
+		if !slices.Contains(props, prop) {
+			props = append(props, prop)
+		}
+	}
+
+	return StateChange{Props: props, Source: StateSourceLocal, State: next.State}, true
+}
+
+// droppable reports whether the change is local, which subscribers miss more safely than changes made by other
+// controllers
+func (c StateChange) droppable() bool {
+	return c.Source == StateSourceLocal
+}
+
+// State returns a snapshot of the current state of the bulb
+func (bi *bulbInfo) State() BulbState {
+	bi.stateMu.RLock()
+	defer bi.stateMu.RUnlock()
+
+	return bi.state
+}
+
+func (bi *bulbInfo) Power() PowerStatus {
+	return bi.State().Power()
+}
+
+func (bi *bulbInfo) Brightness() uint8 {
+	return bi.State().Brightness()
+}
+
+func (bi *bulbInfo) ColorMode() ColorMode {
+	return bi.State().ColorMode()
+}
+
+func (bi *bulbInfo) ColorTemperature() uint16 {
+	return bi.State().ColorTemperature()
+}
+
+func (bi *bulbInfo) RGB() (uint8, uint8, uint8) {
+	return bi.State().RGB()
+}
+
+func (bi *bulbInfo) Hue() uint16 {
+	return bi.State().Hue()
+}
+
+func (bi *bulbInfo) Saturation() uint8 {
+	return bi.State().Saturation()
+}
+
+func (bi *bulbInfo) Name() string {
+	return bi.State().Name()
+}
+
+// DelayOff returns the time left until the sleep timer turns the bulb off, or zero if no timer is set
+func (bi *bulbInfo) DelayOff() time.Duration {
+	return bi.State().DelayOff()
+}
+
+// CurrentScene returns the scene that restores the color and brightness the bulb has now
+func (bi *bulbInfo) CurrentScene() Scene {
+	return bi.sceneOf(bi.State().LightState)
+}
+
+// Subscribe returns a channel receiving every change of the bulb state until the context is done
+func (bi *bulbInfo) Subscribe(ctx context.Context) <-chan StateChange {
+	return bi.stateChanges.subscribe(ctx)
+}
+
+// update applies a change we made to the state
+func (bi *bulbInfo) update(update func(*BulbState)) {
+	bi.stateMu.Lock()
+	defer bi.stateMu.Unlock()
+
+	before := bi.state
+	update(&bi.state)
+
+	props := before.changedProps(bi.state)
+	if len(props) == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(expectedChangeWindow)
+	for _, prop := range props {
+		bi.expectValue(prop, bi.state.propValue(prop), deadline)
+	}
+
+	// Published while holding the lock so that subscribers see the changes in order
+	bi.stateChanges.publish(StateChange{Props: props, Source: StateSourceLocal, State: bi.state})
+}
+
+// expectUpdate expects the values the update would set, without applying it
+func (bi *bulbInfo) expectUpdate(update func(*BulbState)) {
+	bi.stateMu.Lock()
+	defer bi.stateMu.Unlock()
+
+	next := bi.state
+	update(&next)
+
+	deadline := time.Now().Add(expectedChangeWindow)
+	for _, prop := range bi.state.changedProps(next) {
+		bi.expectValue(prop, next.propValue(prop), deadline)
+	}
+}
+
+// applyProps applies props reported by the bulb. Changes to values we recently set ourselves are reported as local,
+// any other as external.
+func (bi *bulbInfo) applyProps(props map[string]string) {
+	bi.stateMu.Lock()
+	defer bi.stateMu.Unlock()
+
+	before := bi.state
+	for key, value := range props {
+		if err := bi.state.setProp(key, value); err != nil {
+			slog.Warn("failed to parse bulb prop", slog.String("prop", key), slog.Any("error", err))
+		}
+	}
+
+	var local, external []string
+	now := time.Now()
+	for _, prop := range before.changedProps(bi.state) {
+		if bi.isExpected(prop, bi.state.propValue(prop), now) {
+			local = append(local, prop)
+		} else {
+			external = append(external, prop)
+		}
+	}
+
+	if len(local) > 0 {
+		bi.stateChanges.publish(StateChange{Props: local, Source: StateSourceLocal, State: bi.state})
+	}
+
+	if len(external) > 0 {
+		bi.stateChanges.publish(StateChange{Props: external, Source: StateSourceExternal, State: bi.state})
+	}
+}
+
+// expect marks the props a relative method changes as changed by us, whatever their new value
+func (bi *bulbInfo) expect(method string) {
+	l, name := methodLight(method)
+	props, ok := relativeMethodProps[name]
+	if !ok {
+		return
+	}
+
+	bi.stateMu.Lock()
+	defer bi.stateMu.Unlock()
+
+	deadline := time.Now().Add(expectedChangeWindow)
+	for _, prop := range props {
+		bi.expectValue(l.prop(prop), anyValue, deadline)
+	}
+}
+
+// expectValue records a value we set, so that the bulb reporting it until the deadline isn't taken for someone else's change
+func (bi *bulbInfo) expectValue(prop, value string, deadline time.Time) {
+	if bi.expected == nil {
+		bi.expected = make(map[string]map[string]time.Time)
+	}
+
+	values, ok := bi.expected[prop]
+	if !ok {
+		values = make(map[string]time.Time)
+		bi.expected[prop] = values
+	}
+
+	// The light show sets a lot of values, so forget the ones that expired
+	for v, d := range values {
+		if deadline.Sub(d) > expectedChangeWindow {
+			delete(values, v)
+		}
+	}
+
+	values[value] = deadline
+}
+
+func (bi *bulbInfo) isExpected(prop, value string, now time.Time) bool {
+	values := bi.expected[prop]
+
+	for _, v := range []string{value, anyValue} {
+		if deadline, ok := values[v]; ok && now.Before(deadline) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,250 @@
+package yeelight
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// TraceConn is the connection a traced message went over
+type TraceConn string
+
+const (
+	TraceControl TraceConn = "control"
+	TraceMusic   TraceConn = "music"
+)
+
+// TraceDirection is whether a traced message was sent to or received from the bulb
+type TraceDirection string
+
+const (
+	TraceSend    TraceDirection = "send"
+	TraceReceive TraceDirection = "receive"
+)
+
+// TraceEvent is a chunk of bytes written to or read from a bulb connection in one call, one line of a trace.
+// Messages may be split across chunks or share one, exactly as they went over the connection.
+type TraceEvent struct {
+	Time      time.Time      `json:"time"`
+	Bulb      string         `json:"bulb"`
+	Conn      TraceConn      `json:"conn"`
+	Direction TraceDirection `json:"dir"`
+	// Data holds the bytes as they were sent or received, base64 encoded if they aren't valid UTF-8
+	Data     string        `json:"data"`
+	Encoding TraceEncoding `json:"encoding,omitempty"`
+}
+
+// TraceEncoding is how the data of a trace event is encoded
+type TraceEncoding string
+
+const (
+	// TraceBase64 is used for chunks that aren't valid UTF-8, e.g. split within a character
+	TraceBase64 TraceEncoding = "base64"
+)
+
+func newTraceEvent(bulb string, conn TraceConn, direction TraceDirection, chunk []byte) TraceEvent {
+	event := TraceEvent{
+		Time:      time.Now(),
+		Bulb:      bulb,
+		Conn:      conn,
+		Direction: direction,
+		Data:      string(chunk),
+	}
+
+	if !utf8.Valid(chunk) {
+		event.Data = base64.StdEncoding.EncodeToString(chunk)
+		event.Encoding = TraceBase64
+	}
+
+	return event
+}
+
+// Bytes returns the bytes as they were sent or received
+func (e TraceEvent) Bytes() ([]byte, error) {
+	switch e.Encoding {
+	case "":
+		return []byte(e.Data), nil
+	case TraceBase64:
+		data, err := base64.StdEncoding.DecodeString(e.Data)
+
+		return data, errors.Wrapf(err, "decode trace data")
+	default:
+		return nil, errors.Errorf("unknown trace encoding %q", e.Encoding)
+	}
+}
+
+// TraceMessage is a line sent to or received from a bulb, put back together from the chunks of a trace
+type TraceMessage struct {
+	// Time is when the chunk completing the line went over the connection
+	Time      time.Time
+	Conn      TraceConn
+	Direction TraceDirection
+	// Line is the message without its line break, which may not be valid JSON
+	Line []byte
+}
+
+// TraceMessages splits the chunks of a trace into the lines they carry, in the order in which they were completed.
+// Bytes of each connection and direction that no line break follows are left out.
+func TraceMessages(events []TraceEvent) []TraceMessage {
+	type stream struct {
+		conn      TraceConn
+		direction TraceDirection
+	}
+
+	var messages []TraceMessage
+	pending := make(map[stream][]byte)
+
+	for _, event := range events {
+		data, err := event.Bytes()
+		if err != nil {
+			slog.Warn("skipping invalid trace event", slog.Any("error", err))
+			continue
+		}
+
+		key := stream{conn: event.Conn, direction: event.Direction}
+		buf := append(pending[key], data...)
+
+		for {
+			i := bytes.IndexByte(buf, '\n')
+			if i == -1 {
+				break
+			}
+
+			if line := bytes.TrimSpace(buf[:i]); len(line) > 0 {
+				messages = append(messages, TraceMessage{
+					Time:      event.Time,
+					Conn:      event.Conn,
+					Direction: event.Direction,
+					Line:      slices.Clone(line),
+				})
+			}
+
+			buf = buf[i+1:]
+		}
+
+		pending[key] = buf
+	}
+
+	return messages
+}
+
+// Tracer writes every chunk of the traced connections as a line of JSON
+type Tracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewTracer(w io.Writer) *Tracer {
+	return &Tracer{w: w}
+}
+
+func (t *Tracer) record(bulb string, conn TraceConn, direction TraceDirection, chunk []byte) {
+	data, err := json.Marshal(newTraceEvent(bulb, conn, direction, chunk))
+	if err != nil {
+		slog.Warn("encode trace event", slog.Any("error", err))
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, err := t.w.Write(append(data, '\n')); err != nil {
+		slog.Warn("write trace event", slog.Any("error", err))
+	}
+}
+
+// ReadTrace reads the events of a trace written by a Tracer
+func ReadTrace(r io.Reader) ([]TraceEvent, error) {
+	var events []TraceEvent
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, errors.Wrapf(err, "decode trace line %d", line)
+		}
+
+		if _, err := event.Bytes(); err != nil {
+			return nil, errors.Wrapf(err, "trace line %d", line)
+		}
+
+		events = append(events, event)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "read trace")
+	}
+
+	return events, nil
+}
+
+// SetTracer records the messages of the control and music mode connections opened from now on
+func (bb *Bulb) SetTracer(tracer *Tracer) {
+	bb.tracer.Store(tracer)
+}
+
+// trace wraps the connection to record its messages, if a tracer is set
+func (bb *Bulb) trace(conn net.Conn, kind TraceConn) net.Conn {
+	tracer := bb.tracer.Load()
+	if tracer == nil {
+		return conn
+	}
+
+	return &tracedConn{
+		Conn:   conn,
+		tracer: tracer,
+		bulb:   bb.Addr().String(),
+		kind:   kind,
+	}
+}
+
+// tracedConn records every chunk read from and written to the connection, as is
+type tracedConn struct {
+	net.Conn
+
+	tracer *Tracer
+	bulb   string
+	kind   TraceConn
+}
+
+func (c *tracedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.tracer.record(c.bulb, c.kind, TraceReceive, p[:n])
+	}
+
+	return n, err
+}
+
+func (c *tracedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.tracer.record(c.bulb, c.kind, TraceSend, p[:n])
+	}
+
+	return n, err
+}
+
+// unwrapConn returns the connection a traced connection wraps
+func unwrapConn(conn net.Conn) net.Conn {
+	if traced, ok := conn.(*tracedConn); ok {
+		return traced.Conn
+	}
+
+	return conn
+}
@@ -0,0 +1,110 @@
+package yeelight
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTraceRecordsChunksVerbatim(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	var out bytes.Buffer
+	conn := &tracedConn{Conn: client, tracer: NewTracer(&out), bulb: "127.0.0.1:55443", kind: TraceControl}
+
+	chunks := []string{`{"id":1,"res`, "ult\":[\"ok\"]}\r\n{\"method\":\"props\",\"params\":{\"power\":\"on\"}}\r\nnot json\r\n{\"id\":2"}
+	go func() {
+		for _, chunk := range chunks {
+			server.Write([]byte(chunk))
+		}
+	}()
+
+	buf := make([]byte, 256)
+	for range chunks {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The messages are readable in the trace
+	if want := `"data":"ult\":[\"ok\"]}\r\n{\"method\":\"props\"`; !strings.Contains(out.String(), want) {
+		t.Errorf("trace doesn't hold the messages as text:\n%s", out.String())
+	}
+
+	events, err := ReadTrace(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != len(chunks) {
+		t.Fatalf("recorded %d events, want %d", len(events), len(chunks))
+	}
+
+	for i, event := range events {
+		if event.Data != chunks[i] || event.Direction != TraceReceive || event.Conn != TraceControl {
+			t.Errorf("event %d = %s %s %q, want receive control %q", i, event.Direction, event.Conn, event.Data, chunks[i])
+		}
+	}
+
+	want := []string{`{"id":1,"result":["ok"]}`, `{"method":"props","params":{"power":"on"}}`, "not json"}
+	messages := TraceMessages(events)
+	if len(messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(want))
+	}
+
+	for i, message := range messages {
+		if string(message.Line) != want[i] {
+			t.Errorf("message %d = %q, want %q", i, message.Line, want[i])
+		}
+	}
+}
+
+func TestTraceEncodesInvalidUTF8(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(&out)
+
+	// A chunk ending within a two byte character
+	chunks := [][]byte{[]byte("{\"name\":\"caf\xc3"), []byte("\xa9\"}\r\n")}
+	for _, chunk := range chunks {
+		tracer.record("127.0.0.1:55443", TraceControl, TraceReceive, chunk)
+	}
+
+	events, err := ReadTrace(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, event := range events {
+		if event.Encoding != TraceBase64 {
+			t.Errorf("event %d has encoding %q, want %q", i, event.Encoding, TraceBase64)
+		}
+
+		data, err := event.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, chunks[i]) {
+			t.Errorf("event %d = %q, want %q", i, data, chunks[i])
+		}
+	}
+
+	messages := TraceMessages(events)
+	if len(messages) != 1 || string(messages[0].Line) != `{"name":"café"}` {
+		t.Fatalf("got messages %v, want the name", messages)
+	}
+}
+
+func TestReadTraceRejectsInvalidData(t *testing.T) {
+	for _, line := range []string{
+		`{"time":"2024-01-01T00:00:00Z","conn":"control","dir":"receive","data":"not base64!","encoding":"base64"}`,
+		`{"time":"2024-01-01T00:00:00Z","conn":"control","dir":"receive","data":"","encoding":"hex"}`,
+	} {
+		if _, err := ReadTrace(strings.NewReader(line)); err == nil {
+			t.Errorf("read invalid trace %s", line)
+		}
+	}
+}
@@ -1,11 +1,11 @@
 package yeelight
 
 import (
+	"cmp"
 	"context"
+	"log/slog"
 	"net"
-	"net/netip"
-	"strconv"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/cybre/yeelight-controller/internal/errors"
@@ -20,133 +20,148 @@ const (
 	ssdpAddress = "239.255.255.250:1982"
 	// line ending (CRLF)
 	lineEnding = "\r\n"
+	// number of times the discover message is sent to survive packet loss
+	discoverAttempts = 3
 )
 
-func Discover(ctx context.Context) ([]Bulb, error) {
-	bulbs := make([]Bulb, 0)
+// Discover searches the local network for bulbs until the context deadline or the discovery timeout expires,
+// whichever comes first. The returned bulbs are de-duplicated by ID and sorted.
+func Discover(ctx context.Context) ([]*Bulb, error) {
+	return discover(ctx, ssdpAddress)
+}
 
-	udpAddr, err := net.ResolveUDPAddr("udp4", ssdpAddress)
-	if err != nil {
-		return nil, errors.Wrapf(err, "resolve SSDP address")
-	}
+// DiscoverAt works like Discover, but sends the search request to the address instead of the multicast group,
+// e.g. to reach a single bulb or a simulated one
+func DiscoverAt(ctx context.Context, address string) ([]*Bulb, error) {
+	return discover(ctx, address)
+}
 
-	conn, err := net.ListenUDP("udp4", udpAddr)
+func discover(ctx context.Context, address string) ([]*Bulb, error) {
+	found := make(map[string]*Bulb)
+	err := search(ctx, address, func(info *bulbInfo) bool {
+		key := info.id
+		if key == "" {
+			key = info.addr.String()
+		}
+
+		found[key] = newBulb(info)
+
+		return false
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "establish connection to SSDP address")
+		return nil, err
 	}
 
-	if _, err = conn.WriteToUDP([]byte(discoverMSG), udpAddr); err != nil {
-		return nil, errors.Wrapf(err, "write discover message to SSDP address")
+	bulbs := make([]*Bulb, 0, len(found))
+	for _, bulb := range found {
+		bulbs = append(bulbs, bulb)
 	}
 
-	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-		return nil, errors.Wrapf(err, "set read deadline for SSDP connection")
+	sortBulbs(bulbs)
+
+	return bulbs, nil
+}
+
+// search sends search requests to the address and hands the bulbs answering them to found, until it returns true
+// or the context deadline or the discovery timeout expires
+func search(ctx context.Context, address string, found func(*bulbInfo) bool) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return errors.Wrapf(err, "resolve SSDP address")
 	}
 
-	buf := make([]byte, 1024)
-	n, _, err := conn.ReadFromUDP(buf)
+	conn, err := net.ListenUDP("udp4", nil)
 	if err != nil {
-		return nil, errors.Wrapf(err, "read from SSDP connection")
+		return errors.Wrapf(err, "establish connection to SSDP address")
+	}
+	defer conn.Close()
+
+	// Unblock the read loop as soon as the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
 	}
 
-	resp := string(buf[:n])
-	lines := strings.Split(resp, lineEnding)
+	interval := time.Until(deadline) / discoverAttempts
 
-	for _, line := range lines {
-		if strings.HasPrefix(line, "Location: yeelight://") {
-			address := strings.TrimPrefix(line, "Location: yeelight://")
-			addr, err := netip.ParseAddrPort(address)
-			if err != nil {
-				return nil, errors.Wrapf(err, "parse bulb address")
+	buf := make([]byte, 2048)
+	sent := 0
+	nextSend := time.Now()
+
+	for time.Now().Before(deadline) {
+		if sent < discoverAttempts && !time.Now().Before(nextSend) {
+			if _, err = conn.WriteToUDP([]byte(discoverMSG), udpAddr); err != nil {
+				return errors.Wrapf(err, "write discover message to SSDP address")
 			}
-			bulbs = append(bulbs, newBulb(addr))
-		}
 
-		if strings.HasPrefix(line, "id:") {
-			id := strings.TrimPrefix(line, "id: ")
-			bulbs[len(bulbs)-1].id = id
+			sent++
+			nextSend = time.Now().Add(interval)
 		}
 
-		if strings.HasPrefix(line, "support:") {
-			support := strings.TrimPrefix(line, "support: ")
-			supportArr := strings.Split(support, " ")
-			bulbs[len(bulbs)-1].support = supportArr
+		readDeadline := deadline
+		if sent < discoverAttempts && nextSend.Before(readDeadline) {
+			readDeadline = nextSend
 		}
 
-		if strings.HasPrefix(line, "power:") {
-			power := strings.TrimPrefix(line, "power: ")
-			bulbs[len(bulbs)-1].power = PowerStatus(power)
+		if err := conn.SetReadDeadline(readDeadline); err != nil {
+			return errors.Wrapf(err, "set read deadline for SSDP connection")
 		}
 
-		if strings.HasPrefix(line, "bright:") {
-			brightness := strings.TrimPrefix(line, "bright: ")
-			brightnessInt, err := strconv.ParseUint(brightness, 10, 8)
-			if err != nil {
-				return nil, errors.Wrapf(err, "convert brightness to int")
-			}
-			bulbs[len(bulbs)-1].brightness = uint8(brightnessInt)
-		}
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				if errors.Is(ctxErr, context.DeadlineExceeded) {
+					break
+				}
 
-		if strings.HasPrefix(line, "color_mode:") {
-			colorMode := strings.TrimPrefix(line, "color_mode: ")
-			colorModeInt, err := strconv.ParseUint(colorMode, 10, 8)
-			if err != nil {
-				return nil, errors.Wrapf(err, "convert color mode to int")
+				return errors.Wrapf(ctxErr, "discover bulbs")
 			}
-			bulbs[len(bulbs)-1].colorMode = ColorMode(colorModeInt)
-		}
 
-		if strings.HasPrefix(line, "ct:") {
-			colorTemperature := strings.TrimPrefix(line, "ct: ")
-			colorTemperatureInt, err := strconv.ParseUint(colorTemperature, 10, 16)
-			if err != nil {
-				return nil, errors.Wrapf(err, " convert color temperature to int")
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
 			}
-			bulbs[len(bulbs)-1].colorTemperature = uint16(colorTemperatureInt)
-		}
 
-		if strings.HasPrefix(line, "rgb:") {
-			rgb := strings.TrimPrefix(line, "rgb: ")
-			rgbInt, err := strconv.ParseUint(rgb, 10, 32)
-			if err != nil {
-				return nil, errors.Wrapf(err, "convert RGB to int")
-			}
-			bulbs[len(bulbs)-1].rgb = uint(rgbInt)
+			return errors.Wrapf(err, "read from SSDP connection")
 		}
 
-		if strings.HasPrefix(line, "hue:") {
-			hue := strings.TrimPrefix(line, "hue: ")
-			hueInt, err := strconv.ParseUint(hue, 10, 16)
-			if err != nil {
-				return nil, errors.Wrapf(err, "convert hue to int")
-			}
-			bulbs[len(bulbs)-1].hue = uint16(hueInt)
+		msg, err := parseSSDPMessage(buf[:n])
+		if err != nil {
+			slog.Debug("ignoring invalid SSDP message", slog.Any("error", err))
+			continue
 		}
 
-		if strings.HasPrefix(line, "sat:") {
-			saturation := strings.TrimPrefix(line, "sat: ")
-			saturationInt, err := strconv.ParseUint(saturation, 10, 8)
-			if err != nil {
-				return nil, errors.Wrapf(err, "convert saturation to int")
-			}
-			bulbs[len(bulbs)-1].saturation = uint8(saturationInt)
+		info, err := msg.bulbInfo()
+		if err != nil {
+			slog.Debug("ignoring invalid SSDP response", slog.Any("error", err))
+			continue
 		}
 
-		if strings.HasPrefix(line, "name:") {
-			name := strings.TrimPrefix(line, "name: ")
-			bulbs[len(bulbs)-1].name = name
+		if found(info) {
+			return nil
 		}
+	}
 
-		if strings.HasPrefix(line, "model:") {
-			model := strings.TrimPrefix(line, "model: ")
-			bulbs[len(bulbs)-1].model = model
-		}
+	return nil
+}
 
-		if strings.HasPrefix(line, "fw_ver:") {
-			firmwareVersion := strings.TrimPrefix(line, "fw_ver: ")
-			bulbs[len(bulbs)-1].firmwareVersion = firmwareVersion
+// sortBulbs sorts bulbs by ID, and bulbs without one by address
+func sortBulbs(bulbs []*Bulb) {
+	slices.SortFunc(bulbs, func(a, b *Bulb) int {
+		if c := cmp.Compare(a.ID(), b.ID()); c != 0 {
+			return c
 		}
-	}
 
-	return bulbs, nil
+		return cmp.Compare(a.Addr().String(), b.Addr().String())
+	})
 }
@@ -0,0 +1,208 @@
+package yeelight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ssdpResponder answers search requests with canned responses, which can come from any number of bulbs
+type ssdpResponder struct {
+	conn     *net.UDPConn
+	wg       sync.WaitGroup
+	requests atomic.Int32
+	// respond returns the responses to the nth search request, counting from 1
+	respond func(n int) []string
+}
+
+func newSSDPResponder(t *testing.T, respond func(n int) []string) *ssdpResponder {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &ssdpResponder{conn: conn, respond: respond}
+	r.wg.Add(1)
+	go r.serve()
+
+	t.Cleanup(func() {
+		conn.Close()
+		r.wg.Wait()
+	})
+
+	return r
+}
+
+func (r *ssdpResponder) serve() {
+	defer r.wg.Done()
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !bytes.Equal(buf[:n], []byte(discoverMSG)) {
+			continue
+		}
+
+		for _, response := range r.respond(int(r.requests.Add(1))) {
+			_, _ = r.conn.WriteToUDP([]byte(response), addr)
+		}
+	}
+}
+
+func (r *ssdpResponder) addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+func ssdpResponse(id, addr string) string {
+	return "HTTP/1.1 200 OK\r\n" +
+		"Cache-Control: max-age=3600\r\n" +
+		"Location: yeelight://" + addr + "\r\n" +
+		"id: " + id + "\r\n" +
+		"model: color\r\n" +
+		"support: get_prop set_power\r\n" +
+		"power: on\r\n"
+}
+
+// discovered returns the IDs and addresses of the bulbs, in order
+func discovered(bulbs []*Bulb) []string {
+	var result []string
+	for _, bulb := range bulbs {
+		result = append(result, bulb.ID()+"@"+bulb.Addr().String())
+	}
+
+	return result
+}
+
+func TestDiscoverDeduplicatesAndSorts(t *testing.T) {
+	responder := newSSDPResponder(t, func(int) []string {
+		return []string{
+			ssdpResponse("0x0000000000000003", "10.0.0.3:55443"),
+			ssdpResponse("0x0000000000000001", "10.0.0.1:55443"),
+			ssdpResponse("", "10.0.0.9:55443"),
+			ssdpResponse("0x0000000000000002", "10.0.0.2:55443"),
+			ssdpResponse("", "10.0.0.10:55443"),
+			// A bulb answering the same request twice
+			ssdpResponse("0x0000000000000001", "10.0.0.1:55443"),
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	bulbs, err := DiscoverAt(ctx, responder.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"@10.0.0.10:55443",
+		"@10.0.0.9:55443",
+		"0x0000000000000001@10.0.0.1:55443",
+		"0x0000000000000002@10.0.0.2:55443",
+		"0x0000000000000003@10.0.0.3:55443",
+	}
+	if got := discovered(bulbs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverRetransmits(t *testing.T) {
+	// Answers the last search request only, like a network losing the others
+	responder := newSSDPResponder(t, func(n int) []string {
+		if n < discoverAttempts {
+			return nil
+		}
+
+		return []string{ssdpResponse("0x0000000000000001", "10.0.0.1:55443")}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	bulbs, err := DiscoverAt(ctx, responder.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := responder.requests.Load(); n != discoverAttempts {
+		t.Errorf("sent %d search requests, want %d", n, discoverAttempts)
+	}
+
+	if got, want := discovered(bulbs), []string{"0x0000000000000001@10.0.0.1:55443"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverIgnoresInvalidResponses(t *testing.T) {
+	responder := newSSDPResponder(t, func(int) []string {
+		return []string{
+			"",
+			"garbage",
+			"HTTP/1.1 200 OK\r\nLocation: http://10.0.0.2/description.xml\r\nid: 0x0000000000000002\r\n",
+			"HTTP/1.1 200 OK\r\nLocation: yeelight://10.0.0.3\r\nid: 0x0000000000000003\r\n",
+			ssdpResponse("0x0000000000000004", "10.0.0.4:55443") + "bright: bright\r\n",
+			ssdpResponse("0x0000000000000001", "10.0.0.1:55443"),
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	bulbs, err := DiscoverAt(ctx, responder.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := discovered(bulbs), []string{"0x0000000000000001@10.0.0.1:55443"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestDiscoverDeadline(t *testing.T) {
+	responder := newSSDPResponder(t, func(int) []string { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	bulbs, err := DiscoverAt(ctx, responder.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bulbs) != 0 {
+		t.Errorf("discovered %v, want none", discovered(bulbs))
+	}
+
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("discovery took %s, beyond the context deadline", elapsed)
+	}
+}
+
+func TestDiscoverCanceled(t *testing.T) {
+	responder := newSSDPResponder(t, func(int) []string { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := DiscoverAt(ctx, responder.addr()); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+
+	if elapsed := time.Since(start); elapsed >= timeout {
+		t.Errorf("discovery took %s after the context was canceled", elapsed)
+	}
+}
@@ -0,0 +1,440 @@
+package yeelighttest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/errors"
+)
+
+// DefaultSupport is the support list of a color bulb
+var DefaultSupport = []string{
+	"get_prop", "set_default", "set_power", "toggle", "set_bright", "start_cf", "stop_cf", "set_scene", "cron_add",
+	"cron_get", "cron_del", "set_ct_abx", "set_rgb", "set_hsv", "set_adjust", "adjust_bright", "adjust_ct",
+	"adjust_color", "set_music", "set_name",
+}
+
+// defaultProps is the state of a bulb that was just plugged in
+var defaultProps = map[string]string{
+	"power":      "on",
+	"bright":     "100",
+	"color_mode": "2",
+	"ct":         "4000",
+	"rgb":        "16777215",
+	"hue":        "0",
+	"sat":        "0",
+	"name":       "",
+	"delayoff":   "0",
+	"music_on":   "0",
+	"bg_power":   "off",
+	"bg_bright":  "100",
+	"bg_lmode":   "2",
+	"bg_ct":      "4000",
+	"bg_rgb":     "16777215",
+	"bg_hue":     "0",
+	"bg_sat":     "0",
+}
+
+// Options configures a simulated bulb. The zero value is a color bulb.
+type Options struct {
+	ID              string
+	Model           string
+	FirmwareVersion string
+	// Support is the list of supported methods, nil for DefaultSupport
+	Support []string
+	// Props overrides the initial props, like "power" or "bright"
+	Props map[string]string
+}
+
+// Command is a command received by the simulated bulb
+type Command struct {
+	ID     int
+	Method string
+	Params []interface{}
+	// Music is whether the command was sent over the music mode connection
+	Music bool
+}
+
+type commandError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// client is a connection to the bulb, written to by its handler and by notifications of other connections
+type client struct {
+	mu    sync.Mutex
+	conn  net.Conn
+	music bool
+}
+
+func (c *client) write(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("encode simulated bulb message", slog.Any("error", err))
+		return
+	}
+
+	c.writeRaw(append(data, '\r', '\n'))
+}
+
+func (c *client) writeRaw(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, _ = c.conn.Write(data)
+}
+
+// Bulb is a simulated bulb serving the control protocol and SSDP search responses on the loopback interface
+type Bulb struct {
+	id              string
+	model           string
+	firmwareVersion string
+	support         []string
+
+	listener net.Listener
+	ssdp     *net.UDPConn
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	props    map[string]string
+	commands []Command
+	errors   map[string][]commandError
+	latency  time.Duration
+	clients  map[*client]struct{}
+	music    *client
+}
+
+// NewBulb starts a simulated bulb, which runs until it is closed
+func NewBulb(opts Options) (*Bulb, error) {
+	b := &Bulb{
+		id:              opts.ID,
+		model:           opts.Model,
+		firmwareVersion: opts.FirmwareVersion,
+		support:         opts.Support,
+		props:           maps.Clone(defaultProps),
+		errors:          make(map[string][]commandError),
+		clients:         make(map[*client]struct{}),
+	}
+
+	if b.id == "" {
+		b.id = "0x0000000000000001"
+	}
+
+	if b.model == "" {
+		b.model = "color"
+	}
+
+	if b.firmwareVersion == "" {
+		b.firmwareVersion = "18"
+	}
+
+	if b.support == nil {
+		b.support = DefaultSupport
+	}
+
+	maps.Copy(b.props, opts.Props)
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrapf(err, "listen for control connections")
+	}
+	b.listener = listener
+
+	ssdp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		listener.Close()
+		return nil, errors.Wrapf(err, "listen for SSDP search requests")
+	}
+	b.ssdp = ssdp
+
+	b.wg.Add(2)
+	go b.accept()
+	go b.serveSSDP()
+
+	return b, nil
+}
+
+// Addr returns the address of the control protocol, as advertised in the Location header
+func (b *Bulb) Addr() netip.AddrPort {
+	return netip.MustParseAddrPort(b.listener.Addr().String())
+}
+
+// SSDPAddr returns the address to send search requests to, e.g. with yeelight.DiscoverAt
+func (b *Bulb) SSDPAddr() string {
+	return b.ssdp.LocalAddr().String()
+}
+
+func (b *Bulb) ID() string {
+	return b.id
+}
+
+// Close stops the bulb and closes all of its connections
+func (b *Bulb) Close() error {
+	err := b.listener.Close()
+	b.ssdp.Close()
+	b.DropConnections()
+	b.wg.Wait()
+
+	return err
+}
+
+// Commands returns every command received so far, in order
+func (b *Bulb) Commands() []Command {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return slices.Clone(b.commands)
+}
+
+// Prop returns the current value of a prop
+func (b *Bulb) Prop(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.props[name]
+}
+
+// SetProp changes a prop like another controller would, notifying every control connection
+func (b *Bulb) SetProp(name, value string) {
+	b.mu.Lock()
+	b.props[name] = value
+	b.mu.Unlock()
+
+	b.notify(map[string]string{name: value})
+}
+
+// SendRaw writes the bytes to every control connection as they are, without adding a line break, e.g. to replay what
+// a bulb sent in a trace
+func (b *Bulb) SendRaw(data []byte) {
+	b.mu.Lock()
+	clients := b.clientList()
+	b.mu.Unlock()
+
+	for _, c := range clients {
+		if !c.music {
+			c.writeRaw(data)
+		}
+	}
+}
+
+// InjectError makes the next command with the method fail with the error
+func (b *Bulb) InjectError(method string, code int, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.errors[method] = append(b.errors[method], commandError{Code: code, Message: message})
+}
+
+// SetLatency delays every reply by the duration
+func (b *Bulb) SetLatency(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.latency = latency
+}
+
+// DropConnections closes the control and music mode connections, like a bulb losing its Wi-Fi would
+func (b *Bulb) DropConnections() {
+	b.mu.Lock()
+	clients := b.clientList()
+	if b.music != nil {
+		clients = append(clients, b.music)
+	}
+	b.mu.Unlock()
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+}
+
+// MusicMode reports whether the bulb is connected to a music mode server
+func (b *Bulb) MusicMode() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.music != nil
+}
+
+// clientList returns the control connections, the caller must hold the lock
+func (b *Bulb) clientList() []*client {
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func (b *Bulb) accept() {
+	defer b.wg.Done()
+
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{conn: conn}
+
+		b.mu.Lock()
+		b.clients[c] = struct{}{}
+		b.mu.Unlock()
+
+		b.wg.Add(1)
+		go b.serve(c)
+	}
+}
+
+// serve handles the commands of a connection until it is closed
+func (b *Bulb) serve(c *client) {
+	defer b.wg.Done()
+	defer func() {
+		c.conn.Close()
+
+		b.mu.Lock()
+		delete(b.clients, c)
+		if b.music == c {
+			b.music = nil
+			b.props["music_on"] = "0"
+		}
+		b.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var cmd struct {
+			ID     int           `json:"id"`
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&cmd); err != nil {
+			slog.Warn("simulated bulb received invalid command", slog.String("command", string(line)), slog.Any("error", err))
+			continue
+		}
+
+		b.handle(c, Command{ID: cmd.ID, Method: cmd.Method, Params: cmd.Params, Music: c.music})
+	}
+}
+
+func (b *Bulb) handle(c *client, cmd Command) {
+	b.mu.Lock()
+	b.commands = append(b.commands, cmd)
+	latency := b.latency
+
+	var injected *commandError
+	if queue := b.errors[cmd.Method]; len(queue) > 0 {
+		injected = &queue[0]
+		b.errors[cmd.Method] = queue[1:]
+	}
+	b.mu.Unlock()
+
+	time.Sleep(latency)
+
+	var result []interface{}
+	var cmdErr *commandError
+	var changed map[string]string
+
+	switch {
+	case injected != nil:
+		cmdErr = injected
+	case !slices.Contains(b.support, cmd.Method):
+		cmdErr = &commandError{Code: -1, Message: "method not supported"}
+	default:
+		result, changed, cmdErr = b.execute(cmd)
+	}
+
+	// Commands sent in music mode are never answered
+	if !c.music {
+		if cmdErr != nil {
+			c.write(map[string]interface{}{"id": cmd.ID, "error": cmdErr})
+		} else {
+			c.write(map[string]interface{}{"id": cmd.ID, "result": result})
+		}
+	}
+
+	if len(changed) > 0 {
+		b.notify(changed)
+	}
+}
+
+// notify sends the changed props to every control connection
+func (b *Bulb) notify(props map[string]string) {
+	params := make(map[string]interface{}, len(props))
+	for key, value := range props {
+		// Real bulbs send numeric props as numbers
+		if n, err := strconv.Atoi(value); err == nil {
+			params[key] = n
+		} else {
+			params[key] = value
+		}
+	}
+
+	b.mu.Lock()
+	clients := b.clientList()
+	b.mu.Unlock()
+
+	for _, c := range clients {
+		if !c.music {
+			c.write(map[string]interface{}{"method": "props", "params": params})
+		}
+	}
+}
+
+// startMusicMode connects to the music mode server, replacing any previous connection
+func (b *Bulb) startMusicMode(host string, port int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 3*time.Second)
+	if err != nil {
+		return errors.Wrapf(err, "connect to music mode server")
+	}
+
+	c := &client{conn: conn, music: true}
+
+	b.mu.Lock()
+	previous := b.music
+	b.music = c
+	b.props["music_on"] = "1"
+	b.mu.Unlock()
+
+	if previous != nil {
+		previous.conn.Close()
+	}
+
+	b.wg.Add(1)
+	go b.serve(c)
+
+	return nil
+}
+
+func (b *Bulb) stopMusicMode() {
+	b.mu.Lock()
+	music := b.music
+	b.music = nil
+	b.props["music_on"] = "0"
+	b.mu.Unlock()
+
+	if music != nil {
+		music.conn.Close()
+	}
+}
@@ -0,0 +1,136 @@
+package yeelighttest_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cybre/yeelight-controller/internal/yeelight"
+	"github.com/cybre/yeelight-controller/internal/yeelight/yeelighttest"
+)
+
+// eventually fails the test unless the condition holds within a few seconds
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBulb(t *testing.T) {
+	fake, err := yeelighttest.NewBulb(yeelighttest.Options{Props: map[string]string{"bright": "30"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	discoverCtx, discoverCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer discoverCancel()
+
+	bulbs, err := yeelight.DiscoverAt(discoverCtx, fake.SSDPAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bulbs) != 1 {
+		t.Fatalf("discovered %d bulbs, want 1", len(bulbs))
+	}
+
+	bulb := bulbs[0]
+	if bulb.ID() != fake.ID() || bulb.Addr() != fake.Addr() {
+		t.Fatalf("discovered %s at %s, want %s at %s", bulb.ID(), bulb.Addr(), fake.ID(), fake.Addr())
+	}
+
+	if err := bulb.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer bulb.Disconnect()
+
+	if got := bulb.Brightness(); got != 30 {
+		t.Fatalf("got brightness %d, want 30", got)
+	}
+
+	t.Run("commands", func(t *testing.T) {
+		if err := bulb.SetBrightness(ctx, 42, yeelight.Sudden, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fake.Prop("bright"); got != "42" {
+			t.Fatalf("got bright %s, want 42", got)
+		}
+
+		fake.InjectError("set_ct_abx", -1, "general error")
+		if err := bulb.SetColorTemperature(ctx, 2700, yeelight.Sudden, 0); err == nil {
+			t.Fatal("command with an injected error succeeded")
+		}
+
+		if err := bulb.SetColorTemperature(ctx, 2700, yeelight.Sudden, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fake.Prop("ct"); got != "2700" {
+			t.Fatalf("got ct %s, want 2700", got)
+		}
+	})
+
+	t.Run("notifications", func(t *testing.T) {
+		fake.SetProp("bright", "7")
+
+		eventually(t, "the brightness notification", func() bool { return bulb.Brightness() == 7 })
+	})
+
+	t.Run("music mode", func(t *testing.T) {
+		server, err := yeelight.NewMusicServer(0, netip.MustParseAddr("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+
+		err = bulb.EnableMusicMode(ctx, server, func(ctx context.Context, mb *yeelight.MusicModeBulb) error {
+			if !fake.MusicMode() {
+				t.Error("simulated bulb not in music mode")
+			}
+
+			if err := mb.SetBrightness(ctx, 64, yeelight.Sudden, 0); err != nil {
+				return err
+			}
+
+			eventually(t, "the music mode command", func() bool {
+				commands := fake.Commands()
+				last := commands[len(commands)-1]
+
+				return last.Method == "set_bright" && last.Music && fake.Prop("bright") == "64"
+			})
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("dropped connections", func(t *testing.T) {
+		fake.DropConnections()
+
+		eventually(t, "the lost connection", func() bool { return bulb.ConnectionState() != yeelight.Connected })
+		eventually(t, "the reconnection", func() bool { return bulb.ConnectionState() == yeelight.Connected })
+
+		if err := bulb.SetBrightness(ctx, 100, yeelight.Sudden, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := fake.Prop("bright"); got != "100" {
+			t.Fatalf("got bright %s, want 100", got)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package yeelighttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errInvalidParams = &commandError{Code: -1, Message: "invalid params"}
+
+// execute runs a supported command and returns its result and the props it changed
+func (b *Bulb) execute(cmd Command) ([]interface{}, map[string]string, *commandError) {
+	method := cmd.Method
+	prefix := ""
+	if name, ok := strings.CutPrefix(method, "bg_"); ok {
+		method, prefix = name, "bg_"
+	}
+
+	// The color mode of the background light is called lmode
+	colorMode := prefix + "color_mode"
+	if prefix != "" {
+		colorMode = "bg_lmode"
+	}
+
+	switch method {
+	case "get_prop":
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		result := make([]interface{}, len(cmd.Params))
+		for i, param := range cmd.Params {
+			result[i] = b.props[fmt.Sprint(param)]
+		}
+
+		return result, nil, nil
+	case "set_power":
+		power, ok := stringParam(cmd.Params, 0)
+		if !ok || power != "on" && power != "off" {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{prefix + "power": power})
+	case "toggle":
+		power := "on"
+		if b.Prop(prefix+"power") == "on" {
+			power = "off"
+		}
+
+		return b.ok(map[string]string{prefix + "power": power})
+	case "set_bright":
+		bright, ok := intParam(cmd.Params, 0)
+		if !ok || bright < 1 || bright > 100 {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{prefix + "bright": strconv.Itoa(bright)})
+	case "adjust_bright":
+		percentage, ok := intParam(cmd.Params, 0)
+		if !ok || percentage < -100 || percentage > 100 {
+			return nil, nil, errInvalidParams
+		}
+
+		bright, _ := strconv.Atoi(b.Prop(prefix + "bright"))
+		bright = min(max(bright+percentage, 1), 100)
+
+		return b.ok(map[string]string{prefix + "bright": strconv.Itoa(bright)})
+	case "set_rgb":
+		rgb, ok := intParam(cmd.Params, 0)
+		if !ok || rgb < 0 || rgb > 0xFFFFFF {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{prefix + "rgb": strconv.Itoa(rgb), colorMode: "1"})
+	case "set_hsv":
+		hue, hueOK := intParam(cmd.Params, 0)
+		sat, satOK := intParam(cmd.Params, 1)
+		if !hueOK || !satOK || hue < 0 || hue > 359 || sat < 0 || sat > 100 {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{prefix + "hue": strconv.Itoa(hue), prefix + "sat": strconv.Itoa(sat), colorMode: "3"})
+	case "set_ct_abx":
+		ct, ok := intParam(cmd.Params, 0)
+		if !ok || ct < 1700 || ct > 6500 {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{prefix + "ct": strconv.Itoa(ct), colorMode: "2"})
+	case "start_cf":
+		changed, ok := flowChanges(cmd.Params, prefix, colorMode)
+		if !ok {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(changed)
+	case "set_scene":
+		changed, ok := sceneChanges(cmd.Params, prefix, colorMode)
+		if !ok {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(changed)
+	case "set_name":
+		name, ok := stringParam(cmd.Params, 0)
+		if !ok {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{"name": name})
+	case "cron_add":
+		delay, ok := intParam(cmd.Params, 1)
+		if !ok || delay < 1 {
+			return nil, nil, errInvalidParams
+		}
+
+		return b.ok(map[string]string{"delayoff": strconv.Itoa(delay)})
+	case "cron_get":
+		delay, _ := strconv.Atoi(b.Prop("delayoff"))
+		if delay == 0 {
+			return []interface{}{}, nil, nil
+		}
+
+		return []interface{}{map[string]int{"type": 0, "delay": delay, "mix": 0}}, nil, nil
+	case "cron_del":
+		return b.ok(map[string]string{"delayoff": "0"})
+	case "set_music":
+		action, ok := intParam(cmd.Params, 0)
+		if !ok {
+			return nil, nil, errInvalidParams
+		}
+
+		if action == 0 {
+			b.stopMusicMode()
+			return []interface{}{"ok"}, nil, nil
+		}
+
+		host, hostOK := stringParam(cmd.Params, 1)
+		port, portOK := intParam(cmd.Params, 2)
+		if !hostOK || !portOK {
+			return nil, nil, errInvalidParams
+		}
+
+		if err := b.startMusicMode(host, port); err != nil {
+			return nil, nil, &commandError{Code: -1, Message: err.Error()}
+		}
+
+		return []interface{}{"ok"}, nil, nil
+	default:
+		// Supported, but not simulated
+		return []interface{}{"ok"}, nil, nil
+	}
+}
+
+// ok applies the changed props, leaving out the ones that didn't actually change, like a real bulb's notification
+func (b *Bulb) ok(props map[string]string) ([]interface{}, map[string]string, *commandError) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	changed := make(map[string]string, len(props))
+	for key, value := range props {
+		if b.props[key] != value {
+			b.props[key] = value
+			changed[key] = value
+		}
+	}
+
+	return []interface{}{"ok"}, changed, nil
+}
+
+// flowChanges returns the props a flow leaves behind. Flows that run forever or recover the previous state leave none.
+func flowChanges(params []interface{}, prefix, colorMode string) (map[string]string, bool) {
+	count, countOK := intParam(params, 0)
+	action, actionOK := intParam(params, 1)
+	expression, expressionOK := stringParam(params, 2)
+	if !countOK || !actionOK || !expressionOK {
+		return nil, false
+	}
+
+	fields := strings.Split(expression, ",")
+	if len(fields)%4 != 0 {
+		return nil, false
+	}
+
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, false
+		}
+
+		values[i] = value
+	}
+
+	changed := make(map[string]string)
+	if count == 0 {
+		return changed, true
+	}
+
+	switch action {
+	case 1:
+		for i := 0; i < len(values); i += 4 {
+			mode, value, bright := values[i+1], values[i+2], values[i+3]
+
+			switch mode {
+			case 1:
+				changed[prefix+"rgb"] = strconv.Itoa(value)
+				changed[colorMode] = "1"
+			case 2:
+				changed[prefix+"ct"] = strconv.Itoa(value)
+				changed[colorMode] = "2"
+			case 7:
+				continue
+			}
+
+			if bright > 0 {
+				changed[prefix+"bright"] = strconv.Itoa(bright)
+			}
+		}
+	case 2:
+		changed[prefix+"power"] = "off"
+	}
+
+	return changed, true
+}
+
+// sceneChanges returns the props a scene sets, which always turns the light on
+func sceneChanges(params []interface{}, prefix, colorMode string) (map[string]string, bool) {
+	class, ok := stringParam(params, 0)
+	if !ok {
+		return nil, false
+	}
+
+	changed := map[string]string{prefix + "power": "on"}
+	ints := func(names ...string) bool {
+		for i, name := range names {
+			value, ok := intParam(params, i+1)
+			if !ok {
+				return false
+			}
+
+			changed[name] = strconv.Itoa(value)
+		}
+
+		return true
+	}
+
+	switch class {
+	case "color":
+		changed[colorMode] = "1"
+		return changed, ints(prefix+"rgb", prefix+"bright")
+	case "hsv":
+		changed[colorMode] = "3"
+		return changed, ints(prefix+"hue", prefix+"sat", prefix+"bright")
+	case "ct":
+		changed[colorMode] = "2"
+		return changed, ints(prefix+"ct", prefix+"bright")
+	case "auto_delay_off":
+		return changed, ints(prefix+"bright", "delayoff")
+	case "cf":
+		flow, ok := flowChanges(params[1:], prefix, colorMode)
+		for key, value := range flow {
+			changed[key] = value
+		}
+
+		return changed, ok
+	default:
+		return nil, false
+	}
+}
+
+func intParam(params []interface{}, i int) (int, bool) {
+	if i >= len(params) {
+		return 0, false
+	}
+
+	n, ok := params[i].(json.Number)
+	if !ok {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(n.String())
+
+	return value, err == nil
+}
+
+func stringParam(params []interface{}, i int) (string, bool) {
+	if i >= len(params) {
+		return "", false
+	}
+
+	s, ok := params[i].(string)
+
+	return s, ok
+}
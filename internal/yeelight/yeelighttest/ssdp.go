@@ -0,0 +1,58 @@
+package yeelighttest
+
+import (
+	"bytes"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// advertisedProps are the props a search response includes, in the order real bulbs send them
+var advertisedProps = []string{"power", "bright", "color_mode", "ct", "rgb", "hue", "sat", "name"}
+
+// serveSSDP answers search requests until the bulb is closed
+func (b *Bulb) serveSSDP() {
+	defer b.wg.Done()
+
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := b.ssdp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !bytes.HasPrefix(bytes.TrimSpace(buf[:n]), []byte("M-SEARCH")) {
+			continue
+		}
+
+		if _, err := b.ssdp.WriteToUDP([]byte(b.advertisement()), addr); err != nil {
+			slog.Warn("simulated bulb failed to answer search request", slog.Any("error", err))
+		}
+	}
+}
+
+// advertisement returns the response to a search request
+func (b *Bulb) advertisement() string {
+	lines := []string{
+		"HTTP/1.1 200 OK",
+		"Cache-Control: max-age=3600",
+		"Location: yeelight://" + b.Addr().String(),
+		"id: " + b.id,
+		"model: " + b.model,
+		"fw_ver: " + b.firmwareVersion,
+		"support: " + strings.Join(b.support, " "),
+	}
+
+	props := advertisedProps
+	if slices.Contains(b.support, "bg_set_power") {
+		props = append(slices.Clone(props), "bg_power", "bg_bright", "bg_lmode", "bg_ct", "bg_rgb", "bg_hue", "bg_sat")
+	}
+
+	b.mu.Lock()
+	for _, prop := range props {
+		lines = append(lines, prop+": "+b.props[prop])
+	}
+	b.mu.Unlock()
+
+	return strings.Join(lines, "\r\n") + "\r\n"
+}